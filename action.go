@@ -0,0 +1,52 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import "fmt"
+
+// Action is a single command a target runs to build, along with the files
+// it reads and writes. Paths are relative to the directory the target is
+// built in unless they are absolute.
+type Action struct {
+	// Description is printed in the build log and names the action in
+	// the cache.
+	Description string
+	Cmd         string
+	Params      []string
+	Env         []string
+	// Inputs are the files the command reads. Directories stand for all
+	// the files in them and patterns are expanded like filepath.Glob.
+	Inputs []string
+	// Outputs are the files the command writes.
+	Outputs []string
+}
+
+func (a *Action) String() string {
+	if a.Description != "" {
+		return a.Description
+	}
+	return a.Cmd
+}
+
+// Planner is implemented by targets that declare the actions they run
+// instead of running commands in Build. An action depends on the last
+// action before it that writes one of it's inputs, actions that don't
+// depend on each other are run in parallel and the outputs of every
+// action are cached on their own.
+type Planner interface {
+	Target
+	Actions() []*Action
+}
+
+// RunActions runs the actions one after the other, it is what planners
+// use to implement Build.
+func RunActions(c *Context, actions []*Action) error {
+	for _, a := range actions {
+		if err := c.Exec(a.Cmd, a.Env, a.Params); err != nil {
+			return fmt.Errorf("%s: %s", a, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Attributes are the attributes every rule accepts regardless of its type,
+// they are interpreted by the builder instead of the rule.
+type Attributes struct {
+	// Retries is the number of times a failed build of the target is
+	// retried before the failure is reported.
+	Retries int
+	// Resources are the resources the target claims while it builds,
+	// they are added to the defaults of its rule.
+	Resources Resources
+	// Exclusive targets are built while nothing else is building.
+	Exclusive bool
+}
+
+// Resources are amounts of resources, like "cpu" cores and "mem_mb"
+// megabytes of memory, by name.
+type Resources map[string]int
+
+func (r Resources) String() string {
+	var s []string
+	for name, n := range r {
+		s = append(s, fmt.Sprintf("%s=%d", name, n))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// Claim is what a target needs to build.
+type Claim struct {
+	Resources Resources
+	Exclusive bool
+}
+
+// Claimer is implemented by rules that need more than a cpu to build, the
+// claims of targets are merged with their attributes.
+type Claimer interface {
+	Claim() Claim
+}
+
+// DefaultClaim is the claim of targets whose rules aren't claimers.
+var DefaultClaim = Claim{Resources: Resources{"cpu": 1}}
+
+// ClaimOf returns the claim of a target with the given attributes.
+func ClaimOf(t Target, a Attributes) Claim {
+	def := DefaultClaim
+	if c, ok := t.(Claimer); ok {
+		def = c.Claim()
+	}
+	c := Claim{
+		Resources: make(Resources),
+		Exclusive: def.Exclusive || a.Exclusive,
+	}
+	for name, n := range def.Resources {
+		c.Resources[name] = n
+	}
+	for name, n := range a.Resources {
+		c.Resources[name] = n
+	}
+	return c
+}
+
+// Set sets the attribute with the given key, it returns false if key isn't
+// a common attribute.
+func (a *Attributes) Set(key string, v interface{}) (bool, error) {
+	switch key {
+	case "retries":
+		n, ok := v.(int)
+		if !ok || n < 0 {
+			return true, fmt.Errorf("retries should be a positive integer not %v", v)
+		}
+		a.Retries = n
+	case "resources":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return true, fmt.Errorf("resources should be a map like {\"cpu\": 4, \"mem_mb\": 2048} not %v", v)
+		}
+		a.Resources = make(Resources)
+		for name, v := range m {
+			n, ok := v.(int)
+			if !ok || n < 0 {
+				return true, fmt.Errorf("resource %s should be a positive integer not %v", name, v)
+			}
+			a.Resources[name] = n
+		}
+	case "exclusive":
+		b, ok := v.(bool)
+		if !ok {
+			return true, fmt.Errorf("exclusive should be true or false not %v", v)
+		}
+		a.Exclusive = b
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -32,22 +32,55 @@ type Target interface {
 // about stdout or stderr outputs.
 type Context struct {
 	wd             string
-	stderr, stdout *bytes.Buffer
+	stderr, stdout io.Writer
 	logger         *log.Logger
 	buf            *bytes.Buffer
+	executor       Executor
+	ctx            context.Context
+	// out and errOut keep what commands wrote to stdout and stderr
+	// apart from the rest of the log.
+	out, errOut bytes.Buffer
+}
+
+// Executor runs the commands targets execute with Context.Exec.
+type Executor interface {
+	// Exec runs cmd in dir, writing its outputs to stdout and stderr. The
+	// command is killed when ctx is done.
+	Exec(ctx context.Context, dir string, stdout, stderr io.Writer, cmd string, env, params []string) error
 }
 
 // NewContext initializes and returns a new build.Context
 func NewContext(dir string) *Context {
 	buf := bytes.Buffer{}
-	return &Context{
-		wd:     dir,
-		stderr: &buf,
-		stdout: &buf,
-		logger: log.New(&buf, "", log.Lmicroseconds),
-		buf:    &buf,
+	c := &Context{
+		wd:       dir,
+		logger:   log.New(&buf, "", log.Lmicroseconds),
+		buf:      &buf,
+		executor: LocalExecutor{},
+		ctx:      context.Background(),
 	}
+	c.stdout = io.MultiWriter(&buf, &c.out)
+	c.stderr = io.MultiWriter(&buf, &c.errOut)
+	return c
+}
+
+// SetExecutor sets the executor commands are run with, by default commands
+// are executed locally.
+func (c *Context) SetExecutor(e Executor) {
+	c.executor = e
+}
+
+// SetContext sets the context commands are run in, they are killed when
+// it is done.
+func (c *Context) SetContext(ctx context.Context) {
+	c.ctx = ctx
 }
+
+// Context returns the context commands are run in.
+func (c *Context) Context() context.Context {
+	return c.ctx
+}
+
 func (c *Context) Stdout() io.Reader {
 	return c.buf
 }
@@ -56,6 +89,12 @@ func (c *Context) StdErr() io.Reader {
 	return c.buf
 }
 
+// Output returns what the commands executed in the context wrote to
+// stdout and stderr.
+func (c *Context) Output() (stdout, stderr []byte) {
+	return c.out.Bytes(), c.errOut.Bytes()
+}
+
 func (c *Context) Printf(format string, v ...interface{}) {
 	c.logger.Printf(format, v)
 }
@@ -67,12 +106,40 @@ func (c *Context) Println(v ...interface{}) {
 // Exec executes a command writing it's outputs to the context
 func (c *Context) Exec(cmd string, env, params []string) error {
 	c.Println(strings.Join(append([]string{cmd}, params...), "\n"))
+	return c.executor.Exec(c.ctx, c.wd, c.stdout, c.stderr, cmd, env, params)
+}
+
+// LocalExecutor executes commands on the local machine.
+type LocalExecutor struct {
+	// Env is added to the environment of every command, replacing the
+	// variables commands set.
+	Env []string
+	// ExtraFiles are inherited by every command, starting with file
+	// descriptor 3.
+	ExtraFiles []*os.File
+}
+
+// Exec executes a command in dir writing it's outputs to stdout and stderr.
+func (e LocalExecutor) Exec(ctx context.Context, dir string, stdout, stderr io.Writer, cmd string, env, params []string) error {
 	var stdOut, stdErr io.ReadCloser
 	var wg sync.WaitGroup
 
-	x := exec.Command(cmd, params...)
-	x.Dir = c.wd
+	x := exec.CommandContext(ctx, cmd, params...)
+	x.Dir = dir
 	x.Env = env
+	if len(e.Env) > 0 {
+		if env == nil {
+			env = os.Environ()
+		}
+		x.Env = nil
+		for _, v := range env {
+			if !hasVar(e.Env, v) {
+				x.Env = append(x.Env, v)
+			}
+		}
+		x.Env = append(x.Env, e.Env...)
+	}
+	x.ExtraFiles = e.ExtraFiles
 	stdErr, err := x.StderrPipe()
 	if err != nil {
 		return err
@@ -85,12 +152,12 @@ func (c *Context) Exec(cmd string, env, params []string) error {
 	wg.Add(2)
 
 	go func() {
-		io.Copy(c.stdout, stdOut)
+		io.Copy(stdout, stdOut)
 		wg.Done()
 	}()
 
 	go func() {
-		io.Copy(c.stderr, stdErr)
+		io.Copy(stderr, stdErr)
 		wg.Done()
 	}()
 
@@ -102,6 +169,17 @@ func (c *Context) Exec(cmd string, env, params []string) error {
 	return nil
 }
 
+// hasVar returns true if the variable v is set in env.
+func hasVar(env []string, v string) bool {
+	name := strings.SplitN(v, "=", 2)[0]
+	for _, e := range env {
+		if strings.SplitN(e, "=", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Run executes a command writing it's outputs to the context
 func (c *Context) Run(ctx context.Context, cmd string, env, params []string) *exec.Cmd {
 	c.Println(strings.Join(append([]string{cmd}, params...), " "))
@@ -0,0 +1,382 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"bldy.build/build"
+	"bldy.build/build/builder/sandbox"
+	"bldy.build/build/cache"
+	"bldy.build/build/util"
+)
+
+// actionCache is the name action results are stored under in the cache.
+const actionCache = "action"
+
+type action struct {
+	*build.Action
+	parents []*action
+	pending int
+	log     lockedBuffer
+
+	stdout, stderr bytes.Buffer
+}
+
+// lockedBuffer is a buffer stdout and stderr of a command can be written to
+// at the same time.
+type lockedBuffer struct {
+	mu sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Buffer.Write(p)
+}
+
+// planActions links every action to the last action before it that writes
+// one of it's inputs.
+func planActions(actions []*build.Action) []*action {
+	var plan []*action
+	producers := make(map[string]*action)
+	for _, a := range actions {
+		x := &action{Action: a}
+		seen := make(map[*action]bool)
+		for _, in := range a.Inputs {
+			if p, ok := producers[filepath.Clean(in)]; ok && !seen[p] {
+				seen[p] = true
+				x.pending++
+				p.parents = append(p.parents, x)
+			}
+		}
+		for _, out := range a.Outputs {
+			producers[filepath.Clean(out)] = x
+		}
+		plan = append(plan, x)
+	}
+	return plan
+}
+
+// runActions runs the actions of a planner in dir, starting each one as
+// soon as the actions it depends on are done. The build log of the
+// actions is returned in the order they were declared.
+func (b *Builder) runActions(n *Node, dir string, actions []*build.Action) ([]byte, error) {
+	plan := planActions(actions)
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		err   error
+		start func(a *action)
+	)
+	start = func(a *action) {
+		defer wg.Done()
+		b.acquire()
+		actionErr := b.runAction(n, dir, a)
+		b.release()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if actionErr != nil {
+			if err == nil {
+				err = actionErr
+			}
+			return
+		}
+		for _, p := range a.parents {
+			p.pending--
+			if p.pending == 0 && err == nil {
+				wg.Add(1)
+				go start(p)
+			}
+		}
+	}
+
+	mu.Lock()
+	for _, a := range plan {
+		if a.pending == 0 {
+			wg.Add(1)
+			go start(a)
+		}
+	}
+	mu.Unlock()
+	wg.Wait()
+
+	var log bytes.Buffer
+	for _, a := range plan {
+		log.Write(a.log.Bytes())
+	}
+	return log.Bytes(), err
+}
+
+func (b *Builder) acquire() {
+	if b.slots != nil {
+		b.slots <- struct{}{}
+	}
+}
+
+func (b *Builder) release() {
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// local returns the executor commands are run with locally, which passes
+// the jobserver on to them.
+func (b *Builder) local() build.Executor {
+	if b.Jobserver == nil {
+		return build.LocalExecutor{}
+	}
+	return build.LocalExecutor{
+		Env:        []string{b.Jobserver.MakeFlags(os.Getenv("MAKEFLAGS"))},
+		ExtraFiles: b.Jobserver.Files(),
+	}
+}
+
+// execCtx returns the context commands are run in.
+func (b *Builder) execCtx() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// job blocks until the jobserver has a free slot for a command that runs
+// locally and returns a function that frees it.
+func (b *Builder) job() func() {
+	if b.Jobserver == nil || b.Executor != nil {
+		return func() {}
+	}
+	t, err := b.Jobserver.Acquire()
+	if err != nil {
+		log.Print(err)
+		return func() {}
+	}
+	return func() {
+		if err := b.Jobserver.Release(t); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// runAction runs a single action, or materializes it's outputs from the
+// cache if it was run with the same command and inputs before.
+func (b *Builder) runAction(n *Node, dir string, a *action) error {
+	key, err := actionKey(dir, a.Action)
+	if err != nil {
+		return fmt.Errorf("%s: %s", a, err.Error())
+	}
+	entry := cache.Entry(actionCache, key)
+	if m, err := cache.Lookup(entry); err == nil && m.Success {
+		if err := cache.Touch(entry); err != nil {
+			fmt.Fprintf(&a.log, "recording access to %s: %s\n", entry, err.Error())
+		}
+		fmt.Fprintf(&a.log, "%s (cached)\n", a)
+		for dst, o := range m.Outputs {
+			p := filepath.Join(dir, dst)
+			os.Remove(p)
+			if err := cache.Materialize(o, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// outputs may be hard links in to the cache, so they are removed
+	// instead of being written to. Outputs the action reads too, like a
+	// binary that is stripped in place, are replaced with copies instead.
+	for _, out := range a.Outputs {
+		p := filepath.Join(dir, out)
+		if reads(a.Action, out) {
+			if err := unlink(p); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("%s: %s", a, err.Error())
+			}
+			continue
+		}
+		os.Remove(p)
+	}
+	fmt.Fprintln(&a.log, strings.Join(append([]string{a.Cmd}, a.Params...), " "))
+	executor := b.local()
+	if b.Executor != nil {
+		executor = b.Executor
+	} else if b.Sandbox {
+		// inputs in the directory are visible anyway.
+		var declared []string
+		for _, in := range a.Inputs {
+			if matches, err := filepath.Glob(in); err == nil && filepath.IsAbs(in) {
+				declared = append(declared, matches...)
+			}
+		}
+		executor = sandbox.New(declared)
+	}
+	release := b.job()
+	err = executor.Exec(b.execCtx(), dir, io.MultiWriter(&a.log, &a.stdout), io.MultiWriter(&a.log, &a.stderr), a.Cmd, a.Env, a.Params)
+	release()
+	b.emitOutput(n, a.String(), a.stdout.Bytes(), a.stderr.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s: %s", a, err.Error())
+	}
+
+	m := cache.Manifest{
+		Name:    a.String(),
+		Success: true,
+		Outputs: make(map[string]cache.Output),
+	}
+	if missing := missingOutputs(dir, a.Outputs); len(missing) > 0 {
+		return fmt.Errorf("%s: didn't produce %s", a, strings.Join(missing, ", "))
+	}
+	for _, out := range a.Outputs {
+		if err := m.Ingest(dir, out, out); err != nil {
+			return err
+		}
+	}
+	return cache.Commit(entry, &m)
+}
+
+// reads returns true if the action declares path as one of its inputs.
+func reads(a *build.Action, path string) bool {
+	for _, in := range a.Inputs {
+		if filepath.Clean(in) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// unlink replaces the file at path with a copy of it, so writing to it
+// doesn't write to the files it is hard linked to.
+func unlink(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), fi.Mode().Perm()|0200); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// actionKey hashes the command of the action along with the contents of
+// it's inputs and the binary it runs. Like target hashes, the environment
+// isn't part of the key, so an action whose command reads flags like
+// CFLAGS from Env is still cached when they change.
+func actionKey(dir string, a *build.Action) ([]byte, error) {
+	h := sha256.New()
+	io.WriteString(h, a.Cmd)
+	if bin, err := exec.LookPath(a.Cmd); err == nil {
+		fi, err := os.Stat(bin)
+		if err != nil {
+			return nil, err
+		}
+		d, err := util.DigestFile(bin, fi)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(d)
+	}
+	for _, p := range a.Params {
+		io.WriteString(h, p)
+		h.Write([]byte{0})
+	}
+	for _, out := range a.Outputs {
+		io.WriteString(h, out)
+		h.Write([]byte{0})
+	}
+	files, err := inputs(dir, a.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		// scratch directories differ between builds, files in the
+		// project are digested once until they change.
+		var d string
+		if rel, err := filepath.Rel(dir, f); err == nil && !strings.HasPrefix(rel, "..") {
+			d, err = cache.Digest(f)
+			if err != nil {
+				return nil, err
+			}
+			f = rel
+		} else {
+			fi, err := os.Stat(f)
+			if err != nil {
+				return nil, err
+			}
+			digest, err := util.DigestFile(f, fi)
+			if err != nil {
+				return nil, err
+			}
+			d = fmt.Sprintf("%x", digest)
+		}
+		fmt.Fprintf(h, "%s %s\n", f, d)
+	}
+	return h.Sum(nil), nil
+}
+
+// inputs expands the inputs of an action to the files they refer to.
+// Inputs that don't exist are skipped, the command will complain about
+// them if they are needed.
+func inputs(dir string, patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			err := filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					seen[path] = true
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	var files []string
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/parser"
+)
+
+func shell(script string, inputs, outputs []string) *build.Action {
+	return &build.Action{
+		Cmd:     "sh",
+		Params:  []string{"-c", script},
+		Inputs:  inputs,
+		Outputs: outputs,
+	}
+}
+
+func TestPlanActions(t *testing.T) {
+	actions := []*build.Action{
+		shell("", []string{"a.c"}, []string{"a.o"}),
+		shell("", []string{"b.c"}, []string{"b.o"}),
+		shell("", []string{"a.o", "./b.o", "a.o"}, []string{"lib.a"}),
+		// actions depend on the last action that wrote their inputs.
+		shell("", []string{"a.c"}, []string{"a.o"}),
+		shell("", []string{"lib.a", "a.o"}, []string{"bin"}),
+	}
+	plan := planActions(actions)
+
+	parents := func(a *action) (idx []int) {
+		for _, p := range a.parents {
+			for i, x := range plan {
+				if x == p {
+					idx = append(idx, i)
+				}
+			}
+		}
+		return idx
+	}
+	tests := []struct {
+		pending int
+		parents string
+	}{
+		{0, "[2]"},
+		{0, "[2]"},
+		{2, "[4]"},
+		{0, "[4]"},
+		{2, "[]"},
+	}
+	for i, test := range tests {
+		if plan[i].pending != test.pending {
+			t.Errorf("action %d waits for %d actions, expected %d", i, plan[i].pending, test.pending)
+		}
+		if p := fmt.Sprint(parents(plan[i])); p != test.parents {
+			t.Errorf("action %d is depended on by %s, expected %s", i, p, test.parents)
+		}
+	}
+}
+
+// newActionBuilder returns a builder that runs actions of a node in a new
+// directory, which it returns along with a function that removes it.
+func newActionBuilder(t *testing.T, slots int) (*Builder, *Node, string, func()) {
+	done := testBuild(t)
+	dir, err := ioutil.TempDir("", "actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Builder{Events: make(chan Event, 64)}
+	b.Reset()
+	b.slots = make(chan struct{}, slots)
+	n := newTestNode("//:actions")
+	n.Url = parser.NewTargetURLFromString(n.Type)
+	return b, n, dir, func() {
+		os.RemoveAll(dir)
+		done()
+	}
+}
+
+func TestRunActionsOrder(t *testing.T) {
+	b, n, dir, done := newActionBuilder(t, 4)
+	defer done()
+	// linking fails if it runs before the objects are compiled.
+	_, err := b.runActions(n, dir, []*build.Action{
+		shell("sleep 0.1; echo a > a.o", nil, []string{"a.o"}),
+		shell("sleep 0.2; echo b > b.o", nil, []string{"b.o"}),
+		shell("cat a.o b.o > lib.a", []string{"a.o", "b.o"}, []string{"lib.a"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytz, _ := ioutil.ReadFile(filepath.Join(dir, "lib.a")); string(bytz) != "a\nb\n" {
+		t.Errorf("lib.a = %q", bytz)
+	}
+}
+
+func TestRunActionsSlots(t *testing.T) {
+	b, n, dir, done := newActionBuilder(t, 2)
+	defer done()
+	// every action records how many actions were running when it started.
+	var actions []*build.Action
+	for i := 0; i < 6; i++ {
+		script := fmt.Sprintf("touch run.%d; ls run.* | wc -l > max.%d; sleep 0.2; rm run.%d", i, i, i)
+		actions = append(actions, shell(script, nil, []string{fmt.Sprintf("max.%d", i)}))
+	}
+	if _, err := b.runActions(n, dir, actions); err != nil {
+		t.Fatal(err)
+	}
+	max := 0
+	for i := range actions {
+		bytz, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("max.%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		running, err := strconv.Atoi(strings.TrimSpace(string(bytz)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if running > max {
+			max = running
+		}
+	}
+	if max != 2 {
+		t.Errorf("at most %d actions ran at once, expected 2", max)
+	}
+}
+
+func TestRunActionsFailure(t *testing.T) {
+	b, n, dir, done := newActionBuilder(t, 4)
+	defer done()
+	_, err := b.runActions(n, dir, []*build.Action{
+		shell("exit 1", nil, []string{"a.o"}),
+		shell("touch lib.a", []string{"a.o"}, []string{"lib.a"}),
+	})
+	if err == nil {
+		t.Fatal("expected the build to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lib.a")); !os.IsNotExist(err) {
+		t.Errorf("an action that depends on one that failed was run")
+	}
+}
+
+func TestRunActionsCached(t *testing.T) {
+	b, n, dir, done := newActionBuilder(t, 4)
+	defer done()
+	count := filepath.Join(dir, "count")
+	// the counter isn't an input, so it doesn't change the key.
+	action := func() *build.Action {
+		return shell("echo run >> "+count+"; echo hello > out.txt", nil, []string{"out.txt"})
+	}
+	for i := 0; i < 2; i++ {
+		// actions are cached apart from the directory they ran in.
+		out := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.Mkdir(out, 0755); err != nil {
+			t.Fatal(err)
+		}
+		log, err := b.runActions(n, out, []*build.Action{action()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached := strings.Contains(string(log), "(cached)"); cached != (i == 1) {
+			t.Errorf("run %d was cached = %t: %s", i, cached, log)
+		}
+		if bytz, _ := ioutil.ReadFile(filepath.Join(out, "out.txt")); string(bytz) != "hello\n" {
+			t.Errorf("run %d: out.txt = %q", i, bytz)
+		}
+	}
+	if bytz, _ := ioutil.ReadFile(count); string(bytz) != "run\n" {
+		t.Errorf("the command ran %d times, expected once", strings.Count(string(bytz), "run"))
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blob := filepath.Join(dir, "blob")
+	if err := ioutil.WriteFile(blob, []byte("binary"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "hello")
+	if err := os.Link(blob, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unlink(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(out, []byte("stripped"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(blob); string(b) != "binary" {
+		t.Errorf("writing to the output wrote %q to the file it was linked to", b)
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().Perm()&0111 == 0 {
+		t.Errorf("the output isn't executable anymore: %v", err)
+	}
+}
@@ -6,31 +6,31 @@
 package builder
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"sync"
 
 	"bldy.build/build"
+	"bldy.build/build/builder/jobserver"
+	"bldy.build/build/builder/remote"
+	"bldy.build/build/cache"
 	"bldy.build/build/parser"
 	"bldy.build/build/postprocessor"
 	"bldy.build/build/processor"
 	"bldy.build/build/util"
 )
 
-type Update struct {
-	TimeStamp time.Time
-	Target    string
-	Status    STATUS
-	Worker    int
-	Cached    bool
-}
-
 type Builder struct {
 	Origin      string
 	Wd          string
@@ -40,23 +40,103 @@ type Builder struct {
 	Done        chan *Node
 	Error       chan error
 	Timeout     chan bool
-	Updates     chan *Node
+	Events      chan Event
 	Root, ptr   *Node
-	pq          *p
+	sched       *scheduler
+
+	// CacheFailures caches failed builds so they aren't rebuilt until
+	// their inputs change. It is set with BUILD_CACHE_FAILURES.
+	CacheFailures bool
+	// RetryFailed rebuilds targets whose failures were cached.
+	RetryFailed bool
+	// Remote is the remote cache results are fetched from before
+	// building targets and uploaded to after. It is set with
+	// BUILD_REMOTE_CACHE.
+	Remote *cache.Remote
+	// Executor runs the commands of targets, they are run locally if it
+	// is nil. It is set with BUILD_REMOTE_EXECUTOR and
+	// BUILD_REMOTE_INSTANCE.
+	Executor build.Executor
+	// Sandbox runs commands that are executed locally in a sandbox
+	// where only the files targets declare are visible. It is set with
+	// BUILD_SANDBOX.
+	Sandbox bool
+	// Budget is the amount of every resource the targets being built can
+	// claim at once. It is set with BUILD_CPUS and BUILD_MEM_MB, and
+	// the number of cpus is used if BUILD_CPUS isn't set.
+	Budget build.Resources
+	// Jobserver is the GNU make jobserver shared by the commands of
+	// targets and the commands they run. The jobserver of make is joined
+	// when the builder is run by make.
+	Jobserver *jobserver.Jobserver
+	// WarnUndeclared warns about files targets leave in their output
+	// directories without declaring them. It is set with
+	// BUILD_WARN_UNDECLARED.
+	WarnUndeclared bool
+
+	hits, misses          int64
+	built, cached, failed int64
+	start                 time.Time
+
+	// spans are recorded for profiles.
+	spans   []span
+	spansMu sync.Mutex
+	loading int
+	// slots limits the number of actions that run at once.
+	slots chan struct{}
+
+	// stop is closed when the build is cancelled, and exited once its
+	// workers stopped. Commands are run in ctx, which is cancelled along
+	// with the build to kill them.
+	stop, exited chan struct{}
+	cancel       *sync.Once
+	timer        *time.Timer
+	ctx          context.Context
+	kill         context.CancelFunc
 }
 
 func New() (c Builder) {
 	c.Nodes = make(map[string]*Node)
 	c.Error = make(chan error)
 	c.Done = make(chan *Node)
-	c.Updates = make(chan *Node)
+	c.Events = make(chan Event, 64)
+	c.Timeout = make(chan bool)
+	c.stop = make(chan struct{})
+	c.exited = make(chan struct{})
+	c.cancel = new(sync.Once)
+	c.ctx, c.kill = context.WithCancel(context.Background())
 	var err error
+	if c.Jobserver, err = jobserver.Join(os.Getenv("MAKEFLAGS")); err != nil {
+		log.Print(err)
+	}
 	c.Wd, err = os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
-	c.pq = newP()
 	c.ProjectPath = util.GetProjectPath()
+	if err := cache.LoadDigests(); err != nil {
+		log.Printf("loading file digests: %s", err.Error())
+	}
+	c.CacheFailures, _ = strconv.ParseBool(util.Getenv("BUILD_CACHE_FAILURES"))
+	c.Sandbox, _ = strconv.ParseBool(util.Getenv("BUILD_SANDBOX"))
+	c.WarnUndeclared, _ = strconv.ParseBool(util.Getenv("BUILD_WARN_UNDECLARED"))
+	c.Budget = build.Resources{"cpu": runtime.NumCPU()}
+	if n, err := strconv.Atoi(util.Getenv("BUILD_CPUS")); err == nil {
+		c.Budget["cpu"] = n
+	}
+	if n, err := strconv.Atoi(util.Getenv("BUILD_MEM_MB")); err == nil {
+		c.Budget["mem_mb"] = n
+	}
+	if url := util.Getenv("BUILD_REMOTE_CACHE"); url != "" {
+		c.Remote = cache.NewRemote(url)
+	}
+	if addr := util.Getenv("BUILD_REMOTE_EXECUTOR"); addr != "" {
+		e, err := remote.New(addr, util.Getenv("BUILD_REMOTE_INSTANCE"), c.ProjectPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Executor = e
+	}
 	return
 }
 
@@ -66,18 +146,37 @@ type Node struct {
 	Type       string
 	Parents    map[string]*Node `json:"-"`
 	Url        parser.TargetURL
+	Attributes build.Attributes `json:"-"`
 	Worker     string
 	Priority   int
-	wg         sync.WaitGroup
+	// Critical is the estimated time it takes to build the node and the
+	// longest chain of nodes that depend on it, from the time they took
+	// in previous builds.
+	Critical   time.Duration
 	Status     STATUS
 	Cached     bool
 	Start, End int64
 	Hash       string
 	Output     string `json:"-"`
-	once       sync.Once
 	sync.Mutex
 	Children map[string]*Node
 	hash     []byte
+	result   *cache.Manifest
+	critSet  bool
+	// pending is the number of children that aren't built yet.
+	pending int
+	claim   build.Claim
+	// inputs are what the hash of the node was computed from.
+	inputs []string
+	// files are the BUILD file the node was declared in and the files
+	// it loaded.
+	files []string
+}
+
+// Result returns what building the node produced, it is nil until the node
+// is built.
+func (n *Node) Result() *cache.Manifest {
+	return n.result
 }
 
 func (n *Node) priority() int {
@@ -90,11 +189,38 @@ func (n *Node) priority() int {
 	}
 	return n.Priority
 }
+
+// critical estimates the critical path length of the node, nodes that
+// weren't built before don't add to it.
+func (n *Node) critical(timings cache.Timings) time.Duration {
+	if n.critSet {
+		return n.Critical
+	}
+	var max time.Duration
+	for _, p := range n.Parents {
+		if c := p.critical(timings); c > max {
+			max = c
+		}
+	}
+	n.Critical = timings[n.Url.String()] + max
+	n.critSet = true
+	return n.Critical
+}
 func (b *Builder) getTarget(url parser.TargetURL) (n *Node) {
 
 	if gnode, ok := b.Nodes[url.String()]; ok {
 		return gnode
 	} else {
+		start := time.Now()
+		defer func() {
+			b.record(span{
+				name:  fmt.Sprintf("evaluate %s", url.String()),
+				cat:   "evaluation",
+				lane:  mainLane,
+				start: start,
+				end:   time.Now(),
+			})
+		}()
 		p, err := processor.NewProcessorFromURL(url, b.Wd)
 		if err != nil {
 			log.Fatal(err)
@@ -112,15 +238,14 @@ func (b *Builder) getTarget(url parser.TargetURL) (n *Node) {
 			}
 
 			node := Node{
-				Target:   t,
-				Type:     fmt.Sprintf("%T", t)[1:],
-				Children: make(map[string]*Node),
-				Parents:  make(map[string]*Node),
-				once:     sync.Once{},
-				wg:       sync.WaitGroup{},
-				Status:   Pending,
-				Url:      xu,
-				Priority: -1,
+				Target:     t,
+				Type:       fmt.Sprintf("%T", t)[1:],
+				Children:   make(map[string]*Node),
+				Parents:    make(map[string]*Node),
+				Status:     Pending,
+				Url:        xu,
+				Attributes: p.Attributes(t.GetName()),
+				Priority:   -1,
 			}
 
 			post := postprocessor.New(url.Package)
@@ -134,7 +259,6 @@ func (b *Builder) getTarget(url parser.TargetURL) (n *Node) {
 
 			for _, d := range node.Target.GetDependencies() {
 				c := b.Add(d)
-				node.wg.Add(1)
 
 				deps = append(deps, c.Target)
 
@@ -153,6 +277,9 @@ func (b *Builder) getTarget(url parser.TargetURL) (n *Node) {
 			}
 
 		}
+		if n != nil {
+			n.files = p.Files()
+		}
 
 		if n == nil {
 			log.Fatalf("we couldn't find target %s", url.String())
@@ -163,9 +290,28 @@ func (b *Builder) getTarget(url parser.TargetURL) (n *Node) {
 }
 
 func (b *Builder) Add(t string) *Node {
+	// the outermost call loads the whole graph.
+	b.loading++
+	if b.loading == 1 {
+		start := time.Now()
+		defer func() {
+			b.record(span{
+				name:  "load graph",
+				cat:   "loading",
+				lane:  mainLane,
+				start: start,
+				end:   time.Now(),
+			})
+		}()
+	}
+	defer func() { b.loading-- }()
 	return b.getTarget(parser.NewTargetURLFromString(t))
 }
 
+// HashNode returns the hash of the target of the node and the outputs of
+// its children, it should only be called once the children are built.
+// Nodes are keyed by what their children produced instead of how, so when
+// a child is rebuilt to the same outputs the node is still cached.
 func (n *Node) HashNode() []byte {
 
 	// node hashes should not change after a build,
@@ -174,18 +320,36 @@ func (n *Node) HashNode() []byte {
 		return n.hash
 	}
 	h := sha1.New()
-	h.Write(n.Target.Hash())
-	util.HashStrings(h, n.Target.GetDependencies())
+	// the inputs are recorded with the result, to explain why the node
+	// is rebuilt when they change.
+	var inputs bytes.Buffer
+	w := io.MultiWriter(h, &inputs)
+	fmt.Fprintf(w, "rule %x\n", n.Target.Hash())
+	fmt.Fprintf(w, "deps %q\n", n.Target.GetDependencies())
 	var bn ByName
 	for _, e := range n.Children {
 		bn = append(bn, e)
 	}
 	sort.Sort(bn)
 	for _, e := range bn {
-		h.Write(e.HashNode())
+		// failed children don't have outputs.
+		if e.result == nil || !e.result.Success {
+			fmt.Fprintf(w, "dep %s unbuilt %x\n", e.Url.String(), e.HashNode())
+			continue
+		}
+		var installs []string
+		for dst := range e.result.Outputs {
+			installs = append(installs, dst)
+		}
+		sort.Strings(installs)
+		for _, dst := range installs {
+			o := e.result.Outputs[dst]
+			fmt.Fprintf(w, "dep %s %s %s %t\n", e.Url.String(), dst, o.Digest, o.Executable)
+		}
 	}
 	n.hash = h.Sum(nil)
 	n.Hash = fmt.Sprintf("%x", n.hash)
+	n.inputs = append(build.Inputs(n.Target), strings.Split(strings.TrimSuffix(inputs.String(), "\n"), "\n")...)
 	return n.hash
 }
 
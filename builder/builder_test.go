@@ -0,0 +1,91 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestBuild builds the targets, the last of which is the root.
+func runTestBuild(t *testing.T, targets ...*testTarget) *Builder {
+	b := newTestBuilder(targets...)
+	go b.Execute(0, 2)
+	for _, err := range b.wait(t) {
+		t.Error(err)
+	}
+	return b
+}
+
+func TestHashNodeEarlyCutoff(t *testing.T) {
+	defer testBuild(t)()
+	parent := func() *testTarget {
+		return &testTarget{
+			Name:   "//:bin",
+			Deps:   []string{"//:lib"},
+			Script: "cat lib.a > bin",
+			Outs:   []string{"bin"},
+		}
+	}
+	lib := &testTarget{Name: "//:lib", Script: "echo lib > lib.a", Outs: []string{"lib.a"}}
+	runTestBuild(t, lib, parent())
+
+	tests := []struct {
+		name   string
+		script string
+		cached bool
+	}{
+		// the rule of the child changed but it built the same outputs.
+		{"same outputs", "echo lib > lib.a; true", true},
+		{"different outputs", "echo lib2 > lib.a", false},
+	}
+	for _, test := range tests {
+		lib := &testTarget{Name: "//:lib", Script: test.script, Outs: []string{"lib.a"}}
+		b := runTestBuild(t, lib, parent())
+		if b.Nodes["//:lib"].Cached {
+			t.Errorf("%s: the child was cached, expected it to be rebuilt", test.name)
+		}
+		if cached := b.Nodes["//:bin"].Cached; cached != test.cached {
+			t.Errorf("%s: the parent was cached = %t, expected %t", test.name, cached, test.cached)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	defer testBuild(t)()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	attempts := filepath.Join(os.Getenv("BUILD_OUT"), "attempts")
+	os.MkdirAll(filepath.Dir(attempts), 0755)
+	lib := &testTarget{Name: "//:lib", Script: fmt.Sprintf("echo x >> %s; exit 1", attempts)}
+	bin := &testTarget{Name: "//:bin", Deps: []string{"//:lib"}, Script: "true"}
+	b := newTestBuilder(lib, bin)
+	b.Nodes["//:lib"].Attributes.Retries = 2
+	b.Nodes["//:bin"].Attributes.Retries = 2
+	go b.Execute(0, 2)
+	if errs := b.wait(t); len(errs) == 0 {
+		t.Fatal("expected the build to fail")
+	}
+
+	bytz, err := ioutil.ReadFile(attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(bytz), "x"); n != 3 {
+		t.Errorf("the failing target was built %d times, expected 3", n)
+	}
+	// the dependency failed, retrying its parent can't fix that.
+	if strings.Contains(logs.String(), "//:bin failed, retrying") {
+		t.Errorf("the parent of the failed target was retried:\n%s", logs.String())
+	}
+}
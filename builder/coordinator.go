@@ -6,180 +6,363 @@ package builder
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"io/ioutil"
 
-	"strings"
+	"sync/atomic"
 
 	"bldy.build/build"
+	"bldy.build/build/builder/install"
+	"bldy.build/build/builder/sandbox"
+	"bldy.build/build/cache"
 	"bldy.build/build/util"
 )
 
-const (
-	SCSSLOG = "success"
-	FAILLOG = "fail"
-	TMP     = "/tmp"
-)
-
 func (b *Builder) Execute(d time.Duration, r int) {
+	b.slots = make(chan struct{}, r)
+	b.start = time.Now()
+
+	// the timer only ever sends on the timeout channel of this build, and
+	// is stopped when it finishes.
+	if d > 0 {
+		timeout, stop := b.Timeout, b.stop
+		b.timer = time.AfterFunc(d, func() {
+			select {
+			case timeout <- true:
+			case <-stop:
+			}
+		})
+	}
+	if b.Root == nil {
+		log.Fatal("Couldn't find the root node.")
+	}
+	b.emit(b.graphLoaded())
 
-	for i := 0; i < r; i++ {
-		go b.work(i)
-
+	timings, err := cache.ReadTimings()
+	if err != nil {
+		log.Printf("reading timings: %s", err.Error())
 	}
+	for _, n := range b.Nodes {
+		n.priority()
+		n.critical(timings)
+	}
+	b.sched = newScheduler(b.Root, b.Budget)
 
+	var workers sync.WaitGroup
+	workers.Add(r)
+	for i := 0; i < r; i++ {
+		go func(i int) {
+			b.work(i)
+			workers.Done()
+		}(i)
+	}
 	go func() {
-		if d > 0 {
-			time.Sleep(d)
-			b.Timeout <- true
+		select {
+		case <-b.stop:
+			b.sched.cancel()
+		case <-b.exited:
 		}
 	}()
-	if b.Root == nil {
-		log.Fatal("Couldn't find the root node.")
-	}
-	b.visit(b.Root)
+	workers.Wait()
+	close(b.exited)
 }
 
-func (b *Builder) build(n *Node) (err error) {
-	var buildErr error
+// Cancel stops the build, the commands that are running are killed and
+// no more targets are started. It returns once every worker has stopped.
+func (b *Builder) Cancel() {
+	b.cancel.Do(func() {
+		b.kill()
+		close(b.stop)
+	})
+	<-b.exited
+}
 
-	nodeHash := fmt.Sprintf("%s-%x", n.Target.GetName(), n.HashNode())
-	outDir := filepath.Join(
-		"/tmp",
-		"build",
-		nodeHash,
-	)
+func (b *Builder) build(n *Node) (err error) {
+	entry := cache.Entry(n.Target.GetName(), n.HashNode())
 	// check if this node was build before
-	if _, err := os.Lstat(outDir); !os.IsNotExist(err) {
-		n.Cached = true
-		if file, err := os.Open(filepath.Join(outDir, FAILLOG)); err == nil {
-			errString, _ := ioutil.ReadAll(file)
-			return fmt.Errorf("%s", errString)
-		} else if _, err := os.Lstat(filepath.Join(outDir, SCSSLOG)); err == nil {
+	if m, err := cache.Lookup(entry); err == nil {
+		if m.Success || (b.CacheFailures && !b.RetryFailed) {
+			n.Cached = true
+			n.result = m
+			if err := cache.Touch(entry); err != nil {
+				log.Printf("recording access to %s: %s", entry, err.Error())
+			}
+			if !m.Success {
+				errString, _ := cache.ReadBlob(m.Log)
+				return fmt.Errorf("%s", errString)
+			}
 			return nil
 		}
+		if err := os.Remove(entry); err != nil {
+			return err
+		}
+	} else if err != cache.ErrNotFound {
+		log.Printf("discarding cached %s: %s", n.Url.String(), err.Error())
+		if err := os.Remove(entry); err != nil {
+			return err
+		}
 	}
 
-	os.MkdirAll(outDir, os.ModeDir|os.ModePerm)
+	key := cache.ActionKey(n.Target.GetName(), n.HashNode())
+	if b.Remote != nil {
+		if m, err := b.Remote.Fetch(key); err == nil && m.Success {
+			if err := cache.Commit(entry, m); err != nil {
+				return err
+			}
+			n.Cached = true
+			n.result = m
+			return nil
+		} else if err != nil && err != cache.ErrNotFound {
+			log.Printf("fetching %s from the remote cache: %s", n.Url.String(), err.Error())
+		}
+	}
 
+	var (
+		outDir   string
+		logBytz  []byte
+		buildErr error
+	)
+	for attempt := 0; ; attempt++ {
+		outDir, logBytz, buildErr = b.run(n)
+		// without a directory the node didn't get to build, there is
+		// nothing to retry.
+		if buildErr == nil || attempt >= n.Attributes.Retries || outDir == "" {
+			break
+		}
+		log.Printf("%s failed, retrying (%d/%d)", n.Url.String(), attempt+1, n.Attributes.Retries)
+		os.RemoveAll(outDir)
+	}
+	if outDir == "" {
+		return buildErr
+	}
+	defer os.RemoveAll(outDir)
+
+	if buildErr == nil || b.CacheFailures {
+		m := cache.Manifest{
+			Name:    n.Url.String(),
+			Success: buildErr == nil,
+			Outputs: make(map[string]cache.Output),
+			Inputs:  n.inputs,
+		}
+		if m.Log, err = cache.PutBytes(logBytz); err != nil {
+			return fmt.Errorf("storing log of %s: %s", n.Url.String(), err.Error())
+		}
+		if buildErr == nil {
+			for dst, src := range n.Target.Installs() {
+				if err := m.Ingest(outDir, dst, src); err != nil {
+					return fmt.Errorf("storing outputs of %s: %s", n.Url.String(), err.Error())
+				}
+			}
+		}
+		if err := cache.Commit(entry, &m); err != nil {
+			return err
+		}
+		n.result = &m
+		if b.Remote != nil && m.Success {
+			if err := b.Remote.Upload(key, &m); err != nil {
+				log.Printf("uploading %s to the remote cache: %s", n.Url.String(), err.Error())
+			}
+		}
+	}
+
+	if buildErr != nil {
+		return fmt.Errorf("%s: \n%s", buildErr, logBytz)
+	}
+	return nil
+}
+
+// run builds the node in a new scratch directory and returns the directory
+// along with the build log. If the node can't be built, because a
+// dependency failed or the directory can't be created, no directory is
+// returned.
+func (b *Builder) run(n *Node) (outDir string, logBytz []byte, buildErr error) {
 	// check failed builds.
 	for _, e := range n.Children {
-		if e.Status == Fail {
-			buildErr = fmt.Errorf("dependency %s failed to build", e.Target.GetName())
-		} else {
-
-			for dst, src := range e.Target.Installs() {
-
-				target := filepath.Base(dst)
-				targetDir := strings.TrimRight(dst, target)
-
-				if targetDir != "" {
-					if err := os.MkdirAll(
-						filepath.Join(
-							outDir,
-							targetDir,
-						),
-						os.ModeDir|os.ModePerm,
-					); err != nil {
-						log.Fatalf("installing dependency %s for %s: %s", e.Target.GetName(), n.Target.GetName(), err.Error())
-					}
-				}
-				os.Symlink(
-					filepath.Join(
-						"/tmp",
-						"build",
-						fmt.Sprintf("%s-%x", e.Target.GetName(), e.HashNode()),
-						src,
-					),
-					filepath.Join(
-						outDir,
-						targetDir,
-						target),
-				)
+		if e.Status == Fail || e.result == nil {
+			return "", nil, fmt.Errorf("dependency %s failed to build", e.Target.GetName())
+		}
+	}
 
+	outDir, err := cache.Scratch(n.Target.GetName())
+	if err != nil {
+		return "", nil, fmt.Errorf("creating scratch directory for %s: %s", n.Target.GetName(), err.Error())
+	}
+	for _, e := range n.Children {
+		for dst, o := range e.result.Outputs {
+			if err := cache.Materialize(o, filepath.Join(outDir, dst)); err != nil {
+				log.Fatalf("installing dependency %s for %s: %s", e.Target.GetName(), n.Target.GetName(), err.Error())
 			}
 		}
 	}
 
 	context := build.NewContext(outDir)
+	context.SetContext(b.execCtx())
+	if b.Executor != nil {
+		context.SetExecutor(b.Executor)
+	} else if b.Sandbox {
+		context.SetExecutor(sandbox.New(build.Paths(n.Target)))
+	} else {
+		context.SetExecutor(b.local())
+	}
 	n.Start = time.Now().UnixNano()
 
-	buildErr = n.Target.Build(context)
-	n.End = time.Now().UnixNano()
-
-	logName := FAILLOG
-	if buildErr == nil {
-		logName = SCSSLOG
-	}
-	if logFile, err := os.Create(filepath.Join(outDir, logName)); err != nil {
-		log.Fatalf("error creating log for %s: %s", n.Target.GetName(), err.Error())
+	if p, ok := n.Target.(build.Planner); ok {
+		logBytz, buildErr = b.runActions(n, outDir, p.Actions())
 	} else {
-		errbytz, err := ioutil.ReadAll(context.Stdout())
+		release := b.job()
+		buildErr = n.Target.Build(context)
+		release()
+		stdout, stderr := context.Output()
+		b.emitOutput(n, n.Target.GetName(), stdout, stderr)
+		logBytz, err = ioutil.ReadAll(context.Stdout())
 		if err != nil {
 			log.Fatalf("error reading log for %s: %s", n.Target.GetName(), err.Error())
 		}
-		n.Output = string(errbytz)
-		_, err = logFile.Write(errbytz)
-		if err != nil {
-			log.Fatalf("error writing log for %s: %s", n.Target.GetName(), err.Error())
-		}
-		if buildErr != nil {
-			return fmt.Errorf("%s: \n%s", buildErr, errbytz)
-		}
+	}
+	n.End = time.Now().UnixNano()
+	if buildErr == nil {
+		buildErr = b.verify(n, outDir)
 	}
 
-	return buildErr
+	n.Output = string(logBytz)
+	return outDir, logBytz, buildErr
 }
 
 func (b *Builder) work(workerNumber int) {
-
-	for {
-		job := b.pq.pop()
+	for job := b.sched.next(); job != nil; job = b.sched.next() {
 		job.Worker = fmt.Sprintf("%d", workerNumber)
-		if job.Status != Pending {
-			continue
-		}
 		job.Lock()
-		defer job.Unlock()
-
-		job.Status = Building
+		b.buildJob(job, workerNumber)
+		job.Unlock()
 
-		b.Updates <- job
-		buildErr := b.build(job)
+		if job.IsRoot {
+			b.finish(job)
+		} else {
+			select {
+			case b.Done <- job:
+			case <-b.stop:
+			}
+		}
+		b.sched.done(job)
+	}
+}
 
-		if buildErr != nil {
-			job.Status = Fail
-			b.Updates <- job
-			b.Error <- buildErr
+func (b *Builder) buildJob(job *Node, workerNumber int) {
+	job.Status = Building
+
+	b.emit(Event{
+		Type:   TargetStarted,
+		Target: job.Url.String(),
+		Worker: workerNumber,
+	})
+	started := time.Now()
+	buildErr := b.build(job)
+	b.recordTarget(job, workerNumber, started, buildErr != nil)
+	if job.Cached {
+		atomic.AddInt64(&b.hits, 1)
+	} else {
+		atomic.AddInt64(&b.misses, 1)
+	}
 
-		} else {
-			job.Status = Success
+	e := Event{
+		Target:   job.Url.String(),
+		Worker:   workerNumber,
+		Duration: time.Duration(job.End - job.Start),
+	}
+	switch {
+	case buildErr != nil:
+		job.Status = Fail
+		atomic.AddInt64(&b.failed, 1)
+		e.Type = TargetFailed
+		e.Error = buildErr.Error()
+	case job.Cached:
+		job.Status = Success
+		atomic.AddInt64(&b.cached, 1)
+		e.Type = TargetCached
+		e.Duration = 0
+		e.Hash = job.Hash
+	default:
+		job.Status = Success
+		atomic.AddInt64(&b.built, 1)
+		e.Type = TargetFinished
+		e.Hash = job.Hash
+	}
+	b.emit(e)
+	if buildErr != nil {
+		b.fail(buildErr)
+	}
+}
 
-			b.Updates <- job
-		}
+// fail sends the error on the error channel, unless the build was
+// cancelled.
+func (b *Builder) fail(err error) {
+	select {
+	case b.Error <- err:
+	case <-b.stop:
+	}
+}
 
-		if !job.IsRoot {
-			b.Done <- job
-			job.once.Do(func() {
-				for _, parent := range job.Parents {
-					parent.wg.Done()
-				}
-			})
-		} else {
-			install(job)
+// finish installs the root, links the outputs of every target and records
+// the stats of the build.
+func (b *Builder) finish(root *Node) {
+	if err := installOut(root); err != nil {
+		log.Printf("installing %s: %s", root.Url.String(), err.Error())
+		b.fail(err)
+	}
+	if err := b.linkTargets(); err != nil {
+		log.Printf("linking the outputs of targets: %s", err.Error())
+	}
 
-			b.Done <- job
-			close(b.Done)
-			return
-		}
+	if err := cache.RecordStats(atomic.LoadInt64(&b.hits), atomic.LoadInt64(&b.misses)); err != nil {
+		log.Printf("recording cache stats: %s", err.Error())
+	}
+	if err := cache.RecordTimings(b.timings()); err != nil {
+		log.Printf("recording timings: %s", err.Error())
+	}
+	if err := cache.RecordDigests(); err != nil {
+		log.Printf("recording file digests: %s", err.Error())
+	}
+	if err := cache.AutoGC(); err != nil {
+		log.Printf("collecting cache: %s", err.Error())
+	}
 
+	summary := &Summary{
+		Total:  b.Total,
+		Built:  int(atomic.LoadInt64(&b.built)),
+		Cached: int(atomic.LoadInt64(&b.cached)),
+		Failed: int(atomic.LoadInt64(&b.failed)),
 	}
+	summary.Success = summary.Failed == 0
+	b.emit(Event{
+		Type:     BuildFinished,
+		Duration: time.Since(b.start),
+		Summary:  summary,
+	})
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	select {
+	case b.Done <- root:
+	case <-b.stop:
+	}
+	close(b.Done)
+}
 
+// timings returns how long the nodes that were built took.
+func (b *Builder) timings() cache.Timings {
+	t := make(cache.Timings)
+	for url, n := range b.Nodes {
+		if n.Status == Success && !n.Cached && n.End > n.Start {
+			t[url] = time.Duration(n.End - n.Start)
+		}
+	}
+	return t
 }
 
 type STATUS int
@@ -194,77 +377,21 @@ const (
 	Building
 )
 
-func (b *Builder) visit(n *Node) {
-
-	// This is not an airplane so let's make sure children get their masks on before the parents.
-	for _, child := range n.Children {
-		// Visit children first
-		go b.visit(child)
+// installOut installs the outputs of the node in to the build_out
+// directory, replacing the outputs it installed there before.
+func installOut(n *Node) error {
+	if n.result == nil || !n.result.Success {
+		return nil
 	}
-
-	n.wg.Wait()
-	n.priority()
-	b.pq.push(n)
-}
-
-func install(job *Node) error {
-	buildOut := util.BuildOut()
-	os.RemoveAll(buildOut)
-	if err := os.MkdirAll(
-		buildOut,
-		os.ModeDir|os.ModePerm,
-	); err != nil {
-		log.Fatalf("copying job %s failed: %s", job.Target.GetName(), err.Error())
-	}
-
-	for dst, src := range job.Target.Installs() {
-
-		target := filepath.Base(dst)
-		targetDir := strings.TrimRight(dst, target)
-
-		buildOutTarget := filepath.Join(
-			buildOut,
-			targetDir,
-		)
-		if err := os.MkdirAll(
-			buildOutTarget,
-			os.ModeDir|os.ModePerm,
-		); err != nil {
-			log.Fatalf("linking job %s failed: %s", job.Target.GetName(), err.Error())
-		}
-		srcp, _ := filepath.EvalSymlinks(
-			filepath.Join(
-				TMP,
-				"build",
-				fmt.Sprintf("%s-%x", job.Target.GetName(), job.HashNode()),
-				src,
-			))
-
-		dstp := filepath.Join(
-			buildOutTarget,
-			target,
-		)
-
-		in, err := os.Open(srcp)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer in.Close()
-		out, err := os.Create(dstp)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer func() {
-			if err := out.Close(); err != nil {
-				log.Fatal(err)
-			}
-		}()
-
-		if _, err := io.Copy(out, in); err != nil {
-			log.Fatal(err)
-		}
-
+	in, err := install.New(util.BuildOut(), install.Copy, false)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	// build_out belongs to build, the outputs of builds from before it
+	// had a manifest are replaced.
+	in.Force = true
+	if _, err := in.Install(n.Url.String(), n.result.Outputs); err != nil {
+		return err
+	}
+	return in.Commit()
 }
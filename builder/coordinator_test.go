@@ -0,0 +1,140 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bldy.build/build"
+	"bldy.build/build/parser"
+)
+
+// testTarget runs a shell script in the directory it is built in and
+// installs the files it names. It is named by its url.
+type testTarget struct {
+	Name   string
+	Deps   []string
+	Script string
+	Outs   []string
+}
+
+func (t *testTarget) GetName() string {
+	return parser.NewTargetURLFromString(t.Name).Target
+}
+
+func (t *testTarget) GetDependencies() []string { return t.Deps }
+
+func (t *testTarget) Hash() []byte {
+	h := sha1.New()
+	io.WriteString(h, t.Name)
+	io.WriteString(h, t.Script)
+	return h.Sum(nil)
+}
+
+func (t *testTarget) Build(c *build.Context) error {
+	return c.Exec("sh", nil, []string{"-c", t.Script})
+}
+
+func (t *testTarget) Installs() map[string]string {
+	installs := make(map[string]string)
+	for _, out := range t.Outs {
+		installs[out] = out
+	}
+	return installs
+}
+
+// testBuild sets the cache and build_out up in a temporary directory, and
+// returns a function that removes it.
+func testBuild(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BUILD_CACHE", filepath.Join(dir, "cache"))
+	os.Setenv("BUILD_OUT", filepath.Join(dir, "build_out"))
+	return func() {
+		os.Unsetenv("BUILD_CACHE")
+		os.Unsetenv("BUILD_OUT")
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestBuilder returns a builder of the targets, the last of which is
+// the root. Targets depend on the targets before them they name.
+func newTestBuilder(targets ...*testTarget) *Builder {
+	b := &Builder{
+		Nodes:  make(map[string]*Node),
+		Error:  make(chan error),
+		Events: make(chan Event, 64),
+	}
+	for _, t := range targets {
+		n := newTestNode(t.Name)
+		n.Target = t
+		n.Url = parser.NewTargetURLFromString(t.Name)
+		n.Status = Pending
+		for _, d := range t.Deps {
+			dependOn(n, b.Nodes[d])
+		}
+		b.Nodes[t.Name] = n
+		b.Root = n
+	}
+	b.Root.IsRoot = true
+	b.Reset()
+	return b
+}
+
+// wait drains the channels of the builder until the build is done, and
+// returns the errors it sent.
+func (b *Builder) wait(t *testing.T) (errs []error) {
+	timeout := time.After(time.Minute)
+	for {
+		select {
+		case <-b.Events:
+		case err := <-b.Error:
+			errs = append(errs, err)
+		case _, ok := <-b.Done:
+			if !ok {
+				return errs
+			}
+		case <-timeout:
+			t.Fatal("the build didn't finish")
+		}
+	}
+}
+
+func TestCancelKillsCommands(t *testing.T) {
+	defer testBuild(t)()
+	b := newTestBuilder(&testTarget{Name: "//:hang", Script: "sleep 60"})
+
+	started := make(chan bool)
+	go func() {
+		for e := range b.Events {
+			if e.Type == TargetStarted {
+				close(started)
+			}
+		}
+	}()
+	go b.Execute(0, 1)
+	<-started
+	// the command has to be running before it can be killed.
+	time.Sleep(100 * time.Millisecond)
+
+	cancelled := make(chan bool)
+	go func() {
+		b.Cancel()
+		close(cancelled)
+	}()
+	select {
+	case <-cancelled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling the build waited for the command to finish")
+	}
+}
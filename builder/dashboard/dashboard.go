@@ -0,0 +1,186 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dashboard serves a web page of a running build and a JSON API
+// over the same data. The dashboard is built from build events, so it can
+// show builds that run in another process too.
+//
+// The API has two endpoints:
+//
+//	GET /api/build                 the build and every target in it
+//	GET /api/target?name=//pkg:tgt a target along with its log
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"bldy.build/build/builder"
+)
+
+// Status of a target.
+const (
+	Pending = "pending"
+	Running = "running"
+	Built   = "built"
+	Cached  = "cached"
+	Failed  = "failed"
+)
+
+// Build is the state of a build.
+type Build struct {
+	// Start and End are unix times in nanoseconds, End is zero while the
+	// build is running.
+	Start   int64            `json:"start"`
+	End     int64            `json:"end"`
+	Summary *builder.Summary `json:"summary,omitempty"`
+	Targets []*Target        `json:"targets"`
+}
+
+// Target is the state of a target in the build.
+type Target struct {
+	Name   string   `json:"name"`
+	Deps   []string `json:"deps"`
+	Status string   `json:"status"`
+	Worker int      `json:"worker"`
+	// Start and End are unix times in nanoseconds, they are zero until
+	// the target starts and finishes building.
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Log is the output of the commands of the target, it is only set
+	// when a single target is requested.
+	Log string `json:"log,omitempty"`
+}
+
+// Dashboard keeps the state of the last build it was sent events of.
+type Dashboard struct {
+	mu      sync.Mutex
+	build   Build
+	targets map[string]*Target
+	mux     *http.ServeMux
+}
+
+// New returns a dashboard with no build.
+func New() *Dashboard {
+	d := &Dashboard{
+		targets: make(map[string]*Target),
+		mux:     http.NewServeMux(),
+	}
+	d.mux.HandleFunc("/", d.page)
+	d.mux.HandleFunc("/api/build", d.serveBuild)
+	d.mux.HandleFunc("/api/target", d.serveTarget)
+	return d
+}
+
+// Handle updates the dashboard with an event, a GraphLoaded event starts
+// a new build.
+func (d *Dashboard) Handle(e builder.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.Type == builder.GraphLoaded {
+		d.build = Build{Start: e.Time.UnixNano()}
+		d.targets = make(map[string]*Target)
+		for _, name := range e.Targets {
+			d.targets[name] = &Target{
+				Name:   name,
+				Deps:   append([]string{}, e.Deps[name]...),
+				Status: Pending,
+			}
+		}
+		return
+	}
+	if e.Type == builder.BuildFinished {
+		d.build.End = e.Time.UnixNano()
+		d.build.Summary = e.Summary
+		return
+	}
+	t, ok := d.targets[e.Target]
+	if !ok {
+		return
+	}
+	switch e.Type {
+	case builder.TargetStarted:
+		t.Status = Running
+		t.Worker = e.Worker
+		t.Start = e.Time.UnixNano()
+	case builder.TargetFinished, builder.TargetCached, builder.TargetFailed:
+		t.Status = map[builder.EventType]string{
+			builder.TargetFinished: Built,
+			builder.TargetCached:   Cached,
+			builder.TargetFailed:   Failed,
+		}[e.Type]
+		t.End = e.Time.UnixNano()
+		t.Hash = e.Hash
+		t.Error = e.Error
+	case builder.ActionOutput:
+		t.Log += e.Output
+	}
+}
+
+// Build returns the state of the build, without the logs of targets.
+func (d *Dashboard) Build() Build {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := d.build
+	b.Targets = []*Target{}
+	for _, t := range d.targets {
+		c := *t
+		c.Log = ""
+		b.Targets = append(b.Targets, &c)
+	}
+	sort.Slice(b.Targets, func(i, j int) bool {
+		return b.Targets[i].Name < b.Targets[j].Name
+	})
+	return b
+}
+
+// Target returns the state of a target, with its log.
+func (d *Dashboard) Target(name string) (Target, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.targets[name]
+	if !ok {
+		return Target{}, false
+	}
+	return *t, true
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *Dashboard) serveBuild(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, d.Build())
+}
+
+func (d *Dashboard) serveTarget(w http.ResponseWriter, r *http.Request) {
+	t, ok := d.Target(r.URL.Query().Get("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, t)
+}
+
+func (d *Dashboard) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// the page polls the api, responses are never fresh for long.
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
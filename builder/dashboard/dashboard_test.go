@@ -0,0 +1,82 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+func TestDashboard(t *testing.T) {
+	d := New()
+	start := time.Unix(100, 0)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	for _, e := range []builder.Event{
+		{Type: builder.GraphLoaded, Time: at(0), Targets: []string{"//:hello", "//lib:foo"}, Deps: map[string][]string{"//:hello": {"//lib:foo"}}},
+		{Type: builder.TargetStarted, Time: at(1), Target: "//lib:foo", Worker: 2},
+		{Type: builder.ActionOutput, Time: at(2), Target: "//lib:foo", Output: "gcc -c foo.c\n"},
+		{Type: builder.TargetFinished, Time: at(3), Target: "//lib:foo", Worker: 2, Hash: "abc"},
+		{Type: builder.TargetStarted, Time: at(3), Target: "//:hello", Worker: 1},
+	} {
+		d.Handle(e)
+	}
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var b Build
+	get("/api/build", &b)
+	expected := Build{
+		Start: at(0).UnixNano(),
+		Targets: []*Target{
+			{Name: "//:hello", Deps: []string{"//lib:foo"}, Status: Running, Worker: 1, Start: at(3).UnixNano()},
+			{Name: "//lib:foo", Deps: []string{}, Status: Built, Worker: 2, Start: at(1).UnixNano(), End: at(3).UnixNano(), Hash: "abc"},
+		},
+	}
+	if !reflect.DeepEqual(b, expected) {
+		got, _ := json.Marshal(b)
+		want, _ := json.Marshal(expected)
+		t.Errorf("got build %s, expected %s", got, want)
+	}
+
+	var tgt Target
+	get("/api/target?name="+url.QueryEscape("//lib:foo"), &tgt)
+	if tgt.Log != "gcc -c foo.c\n" {
+		t.Errorf("got log %q", tgt.Log)
+	}
+	if code := get("/api/target?name=//:nope", nil); code != http.StatusNotFound {
+		t.Errorf("got status %d for a target that isn't in the build", code)
+	}
+	if code := get("/", nil); code != http.StatusOK {
+		t.Errorf("got status %d for the page", code)
+	}
+
+	d.Handle(builder.Event{Type: builder.TargetFailed, Time: at(4), Target: "//:hello", Error: "exit status 1"})
+	d.Handle(builder.Event{Type: builder.BuildFinished, Time: at(4), Summary: &builder.Summary{Total: 2, Built: 1, Failed: 1}})
+	b = d.Build()
+	if b.End != at(4).UnixNano() || b.Summary.Failed != 1 || b.Targets[0].Status != Failed || b.Targets[0].Error != "exit status 1" {
+		t.Errorf("the build didn't finish: %+v", b)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dashboard
+
+// page polls the api and draws the graph of the build, a waterfall of the
+// times targets were built at and the log of the selected target.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>build</title>
+<style>
+body { font: 13px monospace; margin: 0; color: #222; }
+header { padding: 8px 12px; background: #333; color: #eee; }
+main { display: flex; height: calc(100vh - 34px); }
+section { overflow: auto; padding: 8px 12px; }
+#left { flex: 3; border-right: 1px solid #ccc; }
+#right { flex: 2; }
+h2 { font-size: 13px; margin: 12px 0 4px; }
+svg text { font: 11px monospace; cursor: pointer; }
+.pending { fill: #ddd; background: #ddd; }
+.running { fill: #9cf; background: #9cf; }
+.built { fill: #9d9; background: #9d9; }
+.cached { fill: #cec; background: #cec; }
+.failed { fill: #f99; background: #f99; }
+.edge { stroke: #aaa; fill: none; }
+.selected { stroke: #000; stroke-width: 2; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 1px 4px; white-space: nowrap; }
+tr { cursor: pointer; }
+.bar { position: relative; height: 12px; min-width: 2px; }
+pre { white-space: pre-wrap; background: #f6f6f6; padding: 6px; }
+</style>
+</head>
+<body>
+<header id="summary">loading...</header>
+<main>
+<section id="left">
+<h2>graph</h2>
+<svg id="graph"></svg>
+<h2>waterfall</h2>
+<table id="waterfall"></table>
+</section>
+<section id="right">
+<h2 id="name">select a target</h2>
+<div id="details"></div>
+<pre id="log"></pre>
+</section>
+</main>
+<script>
+var selected = null;
+
+function el(tag, attrs, text) {
+	var ns = tag == "svg" || tag == "rect" || tag == "text" || tag == "path" ? "http://www.w3.org/2000/svg" : null;
+	var e = ns ? document.createElementNS(ns, tag) : document.createElement(tag);
+	for (var k in attrs || {}) e.setAttribute(k, attrs[k]);
+	if (text !== undefined) e.textContent = text;
+	return e;
+}
+
+function seconds(ns) { return (ns / 1e9).toFixed(1) + "s"; }
+
+function summary(b) {
+	var counts = {};
+	b.targets.forEach(function(t) { counts[t.status] = (counts[t.status] || 0) + 1; });
+	var now = b.end || Date.now() * 1e6;
+	var s = (b.end ? (b.summary && b.summary.success ? "built" : "failed") : "building") +
+		" " + b.targets.length + " targets in " + seconds(now - b.start);
+	for (var k in counts) s += ", " + counts[k] + " " + k;
+	document.getElementById("summary").textContent = s;
+}
+
+// graph lays targets out in columns by the length of the longest chain of
+// dependencies under them.
+function graph(b) {
+	var byName = {}, depth = {};
+	b.targets.forEach(function(t) { byName[t.name] = t; });
+	function level(name) {
+		if (depth[name] !== undefined) return depth[name];
+		depth[name] = 0;
+		var d = 0;
+		(byName[name] ? byName[name].deps : []).forEach(function(c) { d = Math.max(d, level(c) + 1); });
+		return depth[name] = d;
+	}
+	var columns = [], pos = {};
+	b.targets.forEach(function(t) {
+		var l = level(t.name);
+		(columns[l] = columns[l] || []).push(t);
+	});
+	var w = 220, h = 26;
+	var svg = el("svg", {id: "graph"});
+	var rows = 0;
+	columns.forEach(function(col, x) {
+		col.forEach(function(t, y) { pos[t.name] = {x: x * w + 4, y: y * h + 4}; });
+		rows = Math.max(rows, col.length);
+	});
+	svg.setAttribute("width", columns.length * w);
+	svg.setAttribute("height", rows * h + 8);
+	b.targets.forEach(function(t) {
+		t.deps.forEach(function(d) {
+			if (!pos[d]) return;
+			var a = pos[d], z = pos[t.name];
+			svg.appendChild(el("path", {"class": "edge", d: "M" + (a.x + w - 30) + "," + (a.y + 9) +
+				" C" + (z.x - 15) + "," + (a.y + 9) + " " + (z.x - 15) + "," + (z.y + 9) + " " + z.x + "," + (z.y + 9)}));
+		});
+	});
+	b.targets.forEach(function(t) {
+		var p = pos[t.name];
+		var r = el("rect", {x: p.x, y: p.y, width: w - 30, height: 18, rx: 3,
+			"class": t.status + (t.name == selected ? " selected" : "")});
+		var label = el("text", {x: p.x + 4, y: p.y + 13}, t.name);
+		[r, label].forEach(function(e) {
+			e.onclick = function() { select(t.name); };
+			svg.appendChild(e);
+		});
+	});
+	var old = document.getElementById("graph");
+	old.parentNode.replaceChild(svg, old);
+}
+
+function waterfall(b) {
+	var now = b.end || Date.now() * 1e6;
+	var total = Math.max(now - b.start, 1);
+	var started = b.targets.filter(function(t) { return t.start; });
+	started.sort(function(x, y) { return x.start - y.start; });
+	var table = el("table", {id: "waterfall"});
+	started.forEach(function(t) {
+		var end = t.end || now;
+		var tr = el("tr");
+		tr.onclick = function() { select(t.name); };
+		tr.appendChild(el("td", {}, t.name));
+		tr.appendChild(el("td", {}, seconds(end - t.start)));
+		var td = el("td", {style: "width: 100%"});
+		td.appendChild(el("div", {"class": "bar " + t.status, style: "left: " +
+			(100 * (t.start - b.start) / total) + "%; width: " + (100 * (end - t.start) / total) + "%"}));
+		tr.appendChild(td);
+		table.appendChild(tr);
+	});
+	var old = document.getElementById("waterfall");
+	old.parentNode.replaceChild(table, old);
+}
+
+function select(name) {
+	selected = name;
+	details();
+}
+
+function details() {
+	if (!selected) return;
+	fetch("/api/target?name=" + encodeURIComponent(selected)).then(function(r) {
+		return r.ok ? r.json() : null;
+	}).then(function(t) {
+		if (!t) return;
+		document.getElementById("name").textContent = t.name;
+		var d = document.getElementById("details");
+		d.textContent = "";
+		[["status", t.status], ["worker", t.start ? t.worker : ""], ["hash", t.hash || ""],
+		 ["depends on", t.deps.join(" ")]].forEach(function(kv) {
+			d.appendChild(el("div", {}, kv[0] + ": " + kv[1]));
+		});
+		document.getElementById("log").textContent = (t.error ? t.error + "\n" : "") + (t.log || "");
+	});
+}
+
+function poll() {
+	fetch("/api/build").then(function(r) { return r.json(); }).then(function(b) {
+		summary(b);
+		graph(b);
+		waterfall(b);
+		details();
+	}).catch(function() {
+		document.getElementById("summary").textContent = "the build isn't running anymore";
+	}).then(function() { setTimeout(poll, 1000); });
+}
+poll();
+</script>
+</body>
+</html>
+`
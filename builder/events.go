@@ -0,0 +1,179 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an event.
+type EventType string
+
+const (
+	// GraphLoaded is sent before anything is built, with the targets
+	// that are going to be built.
+	GraphLoaded EventType = "graph_loaded"
+	// TargetStarted is sent when a worker picks up a target.
+	TargetStarted EventType = "target_started"
+	// TargetFinished is sent when a target is built.
+	TargetFinished EventType = "target_finished"
+	// TargetCached is sent when a target is found in the cache.
+	TargetCached EventType = "target_cached"
+	// TargetFailed is sent when a target fails to build.
+	TargetFailed EventType = "target_failed"
+	// ActionOutput is sent with the output of a command a target ran on
+	// stdout or stderr.
+	ActionOutput EventType = "action_output"
+	// BuildFinished is the last event of a build.
+	BuildFinished EventType = "build_finished"
+)
+
+// Event is something that happened during a build. Events are sent on the
+// Events channel of the builder and can be written as newline delimited
+// JSON with an EventWriter.
+type Event struct {
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Target string    `json:"target,omitempty"`
+	// Worker is the worker a target was built on, set on target events.
+	Worker int `json:"worker"`
+
+	// Targets are the targets in the graph and Deps the targets each of
+	// them depends on, set on GraphLoaded.
+	Targets []string            `json:"targets,omitempty"`
+	Deps    map[string][]string `json:"deps,omitempty"`
+	// Duration is how long building took, set on TargetFinished,
+	// TargetFailed and BuildFinished.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	// Error is set on TargetFailed.
+	Error string `json:"error,omitempty"`
+	// Hash is the hash the target is cached by, set on TargetFinished
+	// and TargetCached.
+	Hash string `json:"hash,omitempty"`
+
+	// Action is the command that wrote Output to Stream, set on
+	// ActionOutput.
+	Action string `json:"action,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	Output string `json:"output,omitempty"`
+
+	// Summary is set on BuildFinished.
+	Summary *Summary `json:"summary,omitempty"`
+}
+
+// Summary is the outcome of a build.
+type Summary struct {
+	Success bool `json:"success"`
+	Total   int  `json:"total"`
+	Built   int  `json:"built"`
+	Cached  int  `json:"cached"`
+	Failed  int  `json:"failed"`
+}
+
+// emit sends the event on the events channel.
+func (b *Builder) emit(e Event) {
+	e.Time = time.Now()
+	select {
+	case b.Events <- e:
+	case <-b.stop:
+	}
+}
+
+// emitOutput sends the output of an action, one event for each stream that
+// has output.
+func (b *Builder) emitOutput(n *Node, action string, stdout, stderr []byte) {
+	for _, o := range []struct {
+		stream string
+		output []byte
+	}{{"stdout", stdout}, {"stderr", stderr}} {
+		if len(o.output) == 0 {
+			continue
+		}
+		b.emit(Event{
+			Type:   ActionOutput,
+			Target: n.Url.String(),
+			Action: action,
+			Stream: o.stream,
+			Output: string(o.output),
+		})
+	}
+}
+
+func (b *Builder) graphLoaded() Event {
+	var targets []string
+	deps := make(map[string][]string)
+	for url, n := range b.Nodes {
+		targets = append(targets, url)
+		for _, c := range n.Children {
+			deps[url] = append(deps[url], c.Url.String())
+		}
+		sort.Strings(deps[url])
+	}
+	sort.Strings(targets)
+	return Event{
+		Type:    GraphLoaded,
+		Targets: targets,
+		Deps:    deps,
+	}
+}
+
+// EventWriter writes events as newline delimited JSON.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewEventWriter returns an EventWriter that writes to w.
+func NewEventWriter(w io.Writer) *EventWriter {
+	ew := &EventWriter{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		ew.c = c
+	}
+	return ew
+}
+
+// OpenEventWriter opens the file at path and returns an EventWriter that
+// writes to it. Paths starting with unix: or tcp: are dialed instead.
+func OpenEventWriter(path string) (*EventWriter, error) {
+	var (
+		w   io.Writer
+		err error
+	)
+	switch {
+	case strings.HasPrefix(path, "unix:"):
+		w, err = net.Dial("unix", strings.TrimPrefix(path, "unix:"))
+	case strings.HasPrefix(path, "tcp:"):
+		w, err = net.Dial("tcp", strings.TrimPrefix(path, "tcp:"))
+	default:
+		w, err = os.Create(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewEventWriter(w), nil
+}
+
+// Write writes the event on a single line.
+func (ew *EventWriter) Write(e Event) error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	return ew.enc.Encode(e)
+}
+
+// Close closes the underlying writer if it is a closer.
+func (ew *EventWriter) Close() error {
+	if ew.c == nil {
+		return nil
+	}
+	return ew.c.Close()
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var eventTests = []struct {
+	event Event
+	keys  []string
+}{
+	{
+		Event{Type: GraphLoaded, Targets: []string{"//:a", "//:b"}, Deps: map[string][]string{"//:b": {"//:a"}}},
+		[]string{"deps", "targets", "time", "type", "worker"},
+	},
+	{
+		Event{Type: TargetStarted, Target: "//:a", Worker: 1},
+		[]string{"target", "time", "type", "worker"},
+	},
+	{
+		Event{Type: TargetFinished, Target: "//:a", Duration: time.Second, Hash: "abc"},
+		[]string{"duration_ns", "hash", "target", "time", "type", "worker"},
+	},
+	{
+		Event{Type: TargetCached, Target: "//:a", Hash: "abc"},
+		[]string{"hash", "target", "time", "type", "worker"},
+	},
+	{
+		Event{Type: TargetFailed, Target: "//:a", Duration: time.Second, Error: "exit status 1"},
+		[]string{"duration_ns", "error", "target", "time", "type", "worker"},
+	},
+	{
+		Event{Type: ActionOutput, Target: "//:a", Action: "cc", Stream: "stderr", Output: "warning"},
+		[]string{"action", "output", "stream", "target", "time", "type", "worker"},
+	},
+	{
+		Event{Type: BuildFinished, Duration: time.Second, Summary: &Summary{Success: true, Total: 2, Built: 1, Cached: 1}},
+		[]string{"duration_ns", "summary", "time", "type", "worker"},
+	},
+}
+
+// checkEvents reads the events written by an EventWriter and compares them
+// with eventTests.
+func checkEvents(t *testing.T, bytz []byte) {
+	lines := strings.Split(strings.TrimSuffix(string(bytz), "\n"), "\n")
+	if len(lines) != len(eventTests) {
+		t.Fatalf("expected %d lines got %d:\n%s", len(eventTests), len(lines), bytz)
+	}
+	for i, test := range eventTests {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(lines[i]), &fields); err != nil {
+			t.Fatalf("%s: %s", lines[i], err)
+		}
+		var keys []string
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: expected the fields %v got %v", test.event.Type, test.keys, keys)
+		}
+
+		var e Event
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatal(err)
+		}
+		e.Time = test.event.Time
+		if !reflect.DeepEqual(e, test.event) {
+			t.Errorf("expected %+v got %+v", test.event, e)
+		}
+	}
+}
+
+func writeEvents(t *testing.T, ew *EventWriter) {
+	for _, test := range eventTests {
+		e := test.event
+		e.Time = time.Now()
+		if err := ew.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writeEvents(t, NewEventWriter(&buf))
+	checkEvents(t, buf.Bytes())
+}
+
+func TestOpenEventWriterUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "events.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	read := make(chan []byte)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(read)
+			return
+		}
+		defer conn.Close()
+		bytz, _ := ioutil.ReadAll(conn)
+		read <- bytz
+	}()
+
+	ew, err := OpenEventWriter("unix:" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEvents(t, ew)
+	select {
+	case bytz, ok := <-read:
+		if !ok {
+			t.Fatal("accepting the connection failed")
+		}
+		checkEvents(t, bytz)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the events weren't received")
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package install installs the outputs of targets in to a prefix.
+//
+// Every prefix has a manifest of the files that were installed in to it
+// and the targets they were installed for, so installing a target again
+// only touches the files that changed and removes the ones it doesn't
+// install anymore, and uninstalling removes what was installed and
+// nothing else.
+package install
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"bldy.build/build/cache"
+	"bldy.build/build/util"
+)
+
+// Method is how files are installed.
+type Method string
+
+const (
+	// Copy copies files out of the cache.
+	Copy Method = "copy"
+	// Hardlink links files to the cache, files that can't be linked are
+	// copied.
+	Hardlink Method = "hardlink"
+	// Symlink links files to the cache with symbolic links, which break
+	// when the cache is collected.
+	Symlink Method = "symlink"
+)
+
+// ManifestName is the name of the manifest in the prefix.
+const ManifestName = ".build_install_manifest"
+
+// Manifest is the files that are installed in a prefix, by their path
+// relative to it.
+type Manifest map[string]Entry
+
+// Entry is a file that was installed, with the method it was installed with.
+type Entry struct {
+	Target     string `json:"target"`
+	Digest     string `json:"digest"`
+	Executable bool   `json:"executable"`
+	Method     Method `json:"method"`
+	Stripped   bool   `json:"stripped,omitempty"`
+}
+
+// Installer installs outputs in to a prefix, the manifest of the prefix is
+// written when it is committed.
+type Installer struct {
+	Prefix string
+	Method Method
+	// Strip strips the symbols from executables, which have to be copied
+	// to be stripped.
+	Strip bool
+	// Force overwrites files that are in the prefix but weren't installed
+	// in to it, they are owned by the installer afterwards.
+	Force bool
+
+	manifest Manifest
+}
+
+// New returns an installer for the prefix.
+func New(prefix string, method Method, strip bool) (*Installer, error) {
+	switch method {
+	case Copy, Hardlink, Symlink:
+	default:
+		return nil, fmt.Errorf("can't install files with %q, use copy, hardlink or symlink", method)
+	}
+	if strip && method != Copy {
+		return nil, fmt.Errorf("stripped files can't be linked, they have to be copied")
+	}
+	m, err := ReadManifest(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &Installer{
+		Prefix:   prefix,
+		Method:   method,
+		Strip:    strip,
+		manifest: m,
+	}, nil
+}
+
+// Install installs the outputs of the target, by the paths they are
+// installed at relative to the prefix. Files that are installed already
+// are left alone and the files that were installed for the target before
+// but aren't outputs of it anymore are removed. Files that are in the
+// prefix but aren't in the manifest aren't overwritten unless the
+// installer is forced to. It returns the number of files that were
+// installed.
+func (in *Installer) Install(target string, outputs map[string]cache.Output) (installed int, err error) {
+	var paths []string
+	for dst := range outputs {
+		paths = append(paths, dst)
+	}
+	sort.Strings(paths)
+	for _, dst := range paths {
+		o := outputs[dst]
+		e := Entry{
+			Target:     target,
+			Digest:     o.Digest,
+			Executable: o.Executable,
+			Method:     in.Method,
+			Stripped:   in.Strip && o.Executable,
+		}
+		path := filepath.Join(in.Prefix, dst)
+		old, ok := in.manifest[dst]
+		if ok && old.sameFile(e) {
+			if _, err := os.Lstat(path); err == nil {
+				old.Target = target
+				in.manifest[dst] = old
+				continue
+			}
+		}
+		if _, err := os.Lstat(path); err == nil && !ok && !in.Force {
+			return installed, fmt.Errorf("%s exists and wasn't installed by build, use --force to overwrite it", path)
+		}
+		if err := in.install(o, path, e.Stripped); err != nil {
+			return installed, fmt.Errorf("installing %s: %s", path, err.Error())
+		}
+		in.manifest[dst] = e
+		installed++
+	}
+
+	for dst, e := range in.manifest {
+		if _, ok := outputs[dst]; ok || e.Target != target {
+			continue
+		}
+		if err := remove(in.Prefix, dst); err != nil {
+			return installed, err
+		}
+		delete(in.manifest, dst)
+	}
+	return installed, nil
+}
+
+// sameFile returns true if installing e would leave the file installed for
+// old as it is.
+func (old Entry) sameFile(e Entry) bool {
+	old.Target = e.Target
+	return old == e
+}
+
+// install installs the output at path.
+func (in *Installer) install(o cache.Output, path string, strip bool) error {
+	blob := cache.Blob(o.Digest)
+	stat, err := os.Stat(blob)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0755); err != nil {
+		return err
+	}
+	// files that are installed already may be links in to the cache,
+	// they are replaced instead of written to.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// links have the mode of the blob they point to, blobs with the
+	// wrong mode are copied.
+	if in.Method != Copy && (stat.Mode()&0111 != 0) == o.Executable {
+		if in.Method == Symlink {
+			return os.Symlink(blob, path)
+		}
+		if err := os.Link(blob, path); err == nil {
+			return nil
+		}
+	}
+	return copyFile(blob, path, o.Executable, strip)
+}
+
+// copyFile copies src next to dst and renames it in to place, so dst is
+// never partially written.
+func copyFile(src, dst string, executable, strip bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	var mode os.FileMode = 0644
+	if executable {
+		mode = 0755
+	}
+	if err := os.Chmod(out.Name(), mode); err != nil {
+		return err
+	}
+	if strip && isELF(out.Name()) {
+		if b, err := exec.Command(stripper(), out.Name()).CombinedOutput(); err != nil {
+			return fmt.Errorf("stripping: %s: %s", err.Error(), b)
+		}
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// isELF returns true if the file is an ELF binary, executables that aren't,
+// like scripts, can't be stripped.
+func isELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, []byte("\x7fELF"))
+}
+
+func stripper() string {
+	if strip := util.Getenv("STRIP"); strip != "" {
+		return strip
+	}
+	return util.Getenv("TOOLPREFIX") + "strip"
+}
+
+// Commit writes the manifest of the prefix.
+func (in *Installer) Commit() error {
+	return writeManifest(in.Prefix, in.manifest)
+}
+
+// Uninstall removes the files that were installed in to the prefix for the
+// targets, or for every target if none are given, along with the
+// directories that are left empty. It returns the number of files that
+// were removed.
+func Uninstall(prefix string, targets []string) (removed int, err error) {
+	m, err := ReadManifest(prefix)
+	if err != nil {
+		return 0, err
+	}
+	uninstall := make(map[string]bool)
+	for _, t := range targets {
+		uninstall[t] = true
+	}
+	for dst, e := range m {
+		if len(targets) > 0 && !uninstall[e.Target] {
+			continue
+		}
+		if err := remove(prefix, dst); err != nil {
+			return removed, err
+		}
+		delete(m, dst)
+		removed++
+	}
+	if len(m) == 0 {
+		if err := os.Remove(filepath.Join(prefix, ManifestName)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		return removed, nil
+	}
+	return removed, writeManifest(prefix, m)
+}
+
+// remove removes the file at dst in the prefix, and the directories above
+// it that are left empty.
+func remove(prefix, dst string) error {
+	path := filepath.Join(prefix, dst)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("uninstalling %s: %s", path, err.Error())
+	}
+	for dir := filepath.Dir(path); dir != filepath.Clean(prefix) && dir != "."; dir = filepath.Dir(dir) {
+		// directories that aren't empty aren't removed.
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of the prefix, the manifest of a prefix
+// nothing was installed in to is empty.
+func ReadManifest(prefix string) (Manifest, error) {
+	m := make(Manifest)
+	bytz, err := ioutil.ReadFile(filepath.Join(prefix, ManifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading install manifest: %s", err.Error())
+	}
+	if err := json.Unmarshal(bytz, &m); err != nil {
+		return nil, fmt.Errorf("reading install manifest: %s", err.Error())
+	}
+	return m, nil
+}
+
+func writeManifest(prefix string, m Manifest) error {
+	bytz, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(prefix, os.ModeDir|0755); err != nil {
+		return fmt.Errorf("writing install manifest: %s", err.Error())
+	}
+	tmp, err := ioutil.TempFile(prefix, "."+ManifestName)
+	if err != nil {
+		return fmt.Errorf("writing install manifest: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bytz); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing install manifest: %s", err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing install manifest: %s", err.Error())
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(prefix, ManifestName))
+}
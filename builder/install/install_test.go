@@ -0,0 +1,134 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bldy.build/build/cache"
+)
+
+func put(t *testing.T, dir, name, contents string, mode os.FileMode) cache.Output {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(contents), mode); err != nil {
+		t.Fatal(err)
+	}
+	o, err := cache.Put(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("BUILD_CACHE", filepath.Join(dir, "cache"))
+	defer os.Unsetenv("BUILD_CACHE")
+	prefix := filepath.Join(dir, "prefix")
+	if err := os.MkdirAll(prefix, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := map[string]cache.Output{
+		"bin/hello":           put(t, dir, "hello", "#!/bin/sh\necho hello\n", 0755),
+		"include/foo/hello.h": put(t, dir, "hello.h", "void hello(void);\n", 0644),
+	}
+	unrelated := filepath.Join(prefix, "bin", "unrelated")
+	if err := os.MkdirAll(filepath.Dir(unrelated), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(unrelated, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	install := func(method Method, outputs map[string]cache.Output) int {
+		in, err := New(prefix, method, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := in.Install("//:hello", outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := in.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	mode := func(path string) os.FileMode {
+		fi, err := os.Lstat(filepath.Join(prefix, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Mode()
+	}
+
+	if n := install(Copy, outputs); n != 2 {
+		t.Errorf("installed %d files, expected 2", n)
+	}
+	if m := mode("bin/hello"); m.Perm() != 0755 {
+		t.Errorf("bin/hello was installed with mode %s", m)
+	}
+	if m := mode("include/foo/hello.h"); m.Perm() != 0644 {
+		t.Errorf("include/foo/hello.h was installed with mode %s", m)
+	}
+	if n := install(Copy, outputs); n != 0 {
+		t.Errorf("installed %d files again, expected none", n)
+	}
+
+	// files the target doesn't install anymore are removed.
+	delete(outputs, "include/foo/hello.h")
+	if n := install(Symlink, outputs); n != 1 {
+		t.Errorf("installed %d files with symlinks, expected 1", n)
+	}
+	if m := mode("bin/hello"); m&os.ModeSymlink == 0 {
+		t.Errorf("bin/hello wasn't installed as a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "include")); !os.IsNotExist(err) {
+		t.Errorf("include wasn't removed once it was empty")
+	}
+
+	// files that weren't installed are only overwritten when forced to.
+	in, err := New(prefix, Copy, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clash := map[string]cache.Output{"bin/unrelated": outputs["bin/hello"]}
+	if _, err := in.Install("//:unrelated", clash); err == nil {
+		t.Errorf("a file that wasn't installed was overwritten")
+	}
+	if bytz, err := ioutil.ReadFile(unrelated); err != nil || len(bytz) != 0 {
+		t.Errorf("a file that wasn't installed was changed: %q, %v", bytz, err)
+	}
+	in.Force = true
+	if n, err := in.Install("//:unrelated", clash); err != nil || n != 1 {
+		t.Errorf("forced to install %d files, expected 1: %v", n, err)
+	}
+	// the manifest isn't committed, so the file isn't uninstalled below.
+
+	if _, err := New(prefix, Hardlink, true); err == nil {
+		t.Errorf("stripping files that are hard linked should fail")
+	}
+
+	if n, err := Uninstall(prefix, nil); err != nil || n != 1 {
+		t.Errorf("uninstalled %d files, expected 1: %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "bin", "hello")); !os.IsNotExist(err) {
+		t.Errorf("bin/hello wasn't uninstalled")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("a file that wasn't installed was removed: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, ManifestName)); !os.IsNotExist(err) {
+		t.Errorf("the manifest wasn't removed")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jobserver implements the GNU make jobserver protocol, which
+// shares a number of job slots between make and the processes it starts.
+//
+// A jobserver is a pipe with a byte, a token, in it for every free slot.
+// Every process in the tree owns an implicit slot, and reads a token before
+// starting more jobs than that and writes it back when the job is done.
+package jobserver
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Jobserver is a jobserver created by the builder or one it joined.
+type Jobserver struct {
+	r, w *os.File
+	mu   sync.Mutex
+	// implicit is set when the implicit slot is in use.
+	implicit bool
+}
+
+// Token is a job slot, it has to be released when the job is done.
+type Token struct {
+	b        byte
+	implicit bool
+}
+
+// New creates a jobserver with n slots.
+func New(n int) (*Jobserver, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating jobserver: %s", err.Error())
+	}
+	if n > 1 {
+		if _, err := w.Write([]byte(strings.Repeat("+", n-1))); err != nil {
+			r.Close()
+			w.Close()
+			return nil, fmt.Errorf("creating jobserver: %s", err.Error())
+		}
+	}
+	return &Jobserver{r: r, w: w}, nil
+}
+
+// Join joins the jobserver described in makeflags, which is the value of
+// the MAKEFLAGS environment variable make sets for the commands it runs.
+// It returns nil if there is no jobserver in makeflags.
+func Join(makeflags string) (*Jobserver, error) {
+	var auth string
+	for _, flag := range strings.Fields(makeflags) {
+		for _, prefix := range []string{"--jobserver-auth=", "--jobserver-fds="} {
+			if strings.HasPrefix(flag, prefix) {
+				auth = strings.TrimPrefix(flag, prefix)
+			}
+		}
+	}
+	if auth == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(auth, "fifo:") {
+		f, err := os.OpenFile(strings.TrimPrefix(auth, "fifo:"), os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("joining jobserver: %s", err.Error())
+		}
+		return &Jobserver{r: f, w: f}, nil
+	}
+	fds := strings.Split(auth, ",")
+	if len(fds) != 2 {
+		return nil, fmt.Errorf("joining jobserver: can't parse %q", auth)
+	}
+	var files [2]*os.File
+	for i, fd := range fds {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("joining jobserver: can't parse %q", auth)
+		}
+		if !isPipe(n) {
+			return nil, fmt.Errorf("joining jobserver: fd %d isn't a pipe, the command running the builder may need to be marked with + in the makefile", n)
+		}
+		files[i] = os.NewFile(uintptr(n), "jobserver")
+	}
+	return &Jobserver{r: files[0], w: files[1]}, nil
+}
+
+// Acquire blocks until a slot is free.
+func (j *Jobserver) Acquire() (Token, error) {
+	j.mu.Lock()
+	if !j.implicit {
+		j.implicit = true
+		j.mu.Unlock()
+		return Token{implicit: true}, nil
+	}
+	j.mu.Unlock()
+
+	var b [1]byte
+	for {
+		n, err := j.r.Read(b[:])
+		if err != nil {
+			return Token{}, fmt.Errorf("acquiring jobserver token: %s", err.Error())
+		}
+		if n == 1 {
+			return Token{b: b[0]}, nil
+		}
+	}
+}
+
+// Release frees the slot of a token.
+func (j *Jobserver) Release(t Token) error {
+	if t.implicit {
+		j.mu.Lock()
+		j.implicit = false
+		j.mu.Unlock()
+		return nil
+	}
+	if _, err := j.w.Write([]byte{t.b}); err != nil {
+		return fmt.Errorf("releasing jobserver token: %s", err.Error())
+	}
+	return nil
+}
+
+// Files are the files commands have to inherit to use the jobserver, as
+// file descriptors 3 and 4.
+func (j *Jobserver) Files() []*os.File {
+	return []*os.File{j.r, j.w}
+}
+
+// MakeFlags returns MAKEFLAGS for commands that inherit Files. The flags
+// and variables of makeflags, the MAKEFLAGS the builder was run with, are
+// kept and its jobserver flags are replaced, both the flag used by make
+// 4.2 and later and the one used before are set.
+func (j *Jobserver) MakeFlags(makeflags string) string {
+	var flags, vars []string
+	fields := strings.Fields(makeflags)
+	for i, flag := range fields {
+		// variables set on the command line come after --.
+		if flag == "--" {
+			vars = fields[i:]
+			break
+		}
+		if !isJobsFlag(flag) {
+			flags = append(flags, flag)
+		}
+	}
+	flags = append(flags, "-j", "--jobserver-fds=3,4", "--jobserver-auth=3,4")
+	return "MAKEFLAGS=" + strings.Join(append(flags, vars...), " ")
+}
+
+// isJobsFlag reports whether flag sets the number of jobs or the jobserver.
+func isJobsFlag(flag string) bool {
+	switch {
+	case flag == "-j" || flag == "--jobs":
+		return true
+	case strings.HasPrefix(flag, "-j"):
+		_, err := strconv.Atoi(flag[2:])
+		return err == nil
+	}
+	for _, prefix := range []string{"--jobs=", "--jobserver-auth=", "--jobserver-fds="} {
+		if strings.HasPrefix(flag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the jobserver.
+func (j *Jobserver) Close() error {
+	if j.r == j.w {
+		return j.r.Close()
+	}
+	j.r.Close()
+	return j.w.Close()
+}
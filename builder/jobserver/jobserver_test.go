@@ -0,0 +1,167 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jobserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bldy.build/build"
+)
+
+func TestNew(t *testing.T) {
+	j, err := New(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var tokens []Token
+	for i := 0; i < 3; i++ {
+		tok, err := j.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, tok)
+	}
+	acquired := make(chan Token)
+	go func() {
+		tok, err := j.Acquire()
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- tok
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more tokens than there are slots")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := j.Release(tokens[1]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("released token wasn't acquired")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	w.Write([]byte("+"))
+
+	j, err := Join(fmt.Sprintf(" -j4 --jobserver-auth=%d,%d", r.Fd(), w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("didn't join the jobserver")
+	}
+	for i := 0; i < 2; i++ {
+		tok, err := j.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && tok.b != '+' {
+			t.Errorf("expected token + got %q", tok.b)
+		}
+	}
+}
+
+func TestJoinNoJobserver(t *testing.T) {
+	f, err := ioutil.TempFile("", "jobserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if j, err := Join(" -k"); j != nil || err != nil {
+		t.Errorf("expected no jobserver got %v, %v", j, err)
+	}
+	if _, err := Join(fmt.Sprintf("-j --jobserver-fds=%d,%d", f.Fd(), f.Fd())); err == nil {
+		t.Errorf("expected an error joining a jobserver that isn't a pipe")
+	}
+}
+
+func TestMakeFlags(t *testing.T) {
+	j := &Jobserver{}
+	tests := []struct {
+		makeflags string
+		want      string
+	}{
+		{"", "-j --jobserver-fds=3,4 --jobserver-auth=3,4"},
+		{"ks", "ks -j --jobserver-fds=3,4 --jobserver-auth=3,4"},
+		{"k -j4 --jobserver-auth=7,8", "k -j --jobserver-fds=3,4 --jobserver-auth=3,4"},
+		{" --jobserver-fds=5,6 -j", "-j --jobserver-fds=3,4 --jobserver-auth=3,4"},
+		{"-k --jobs=2 --jobserver-auth=fifo:/tmp/GMfifo1", "-k -j --jobserver-fds=3,4 --jobserver-auth=3,4"},
+		{"s -j -- CC=clang -jx=1", "s -j --jobserver-fds=3,4 --jobserver-auth=3,4 -- CC=clang -jx=1"},
+	}
+	for _, test := range tests {
+		if got := j.MakeFlags(test.makeflags); got != "MAKEFLAGS="+test.want {
+			t.Errorf("MakeFlags(%q) = %q, want %q", test.makeflags, got, "MAKEFLAGS="+test.want)
+		}
+	}
+}
+
+// TestMake checks make runs its jobs with the slots of the jobserver.
+func TestMake(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make isn't installed")
+	}
+	dir, err := ioutil.TempDir("", "jobserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	makefile := "all: a b\na b:\n\t@echo $(MAKEFLAGS) $(GREETING)\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	e := build.LocalExecutor{
+		Env:        []string{j.MakeFlags("k -j8 -- GREETING=hi")},
+		ExtraFiles: j.Files(),
+	}
+	var stdout, stderr bytes.Buffer
+	if err := e.Exec(context.Background(), dir, &stdout, &stderr, "make", nil, nil); err != nil {
+		t.Fatalf("%s: %s", err, stderr.String())
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("make warned: %s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "--jobserver-auth") {
+		t.Errorf("make didn't use the jobserver: %s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "hi\n") {
+		t.Errorf("make didn't get the variables of MAKEFLAGS: %s", stdout.String())
+	}
+
+	// every token make took should be back.
+	for i := 0; i < 2; i++ {
+		if _, err := j.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
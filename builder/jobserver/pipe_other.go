@@ -0,0 +1,12 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package jobserver
+
+func isPipe(fd int) bool {
+	return false
+}
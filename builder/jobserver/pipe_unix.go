@@ -0,0 +1,21 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package jobserver
+
+import "syscall"
+
+// isPipe returns true if fd is an open pipe. make closes the jobserver for
+// commands it doesn't know are recursive but leaves it in MAKEFLAGS, so
+// the fds may be closed or reused by the time they are joined.
+func isPipe(fd int) bool {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return false
+	}
+	return st.Mode&syscall.S_IFMT == syscall.S_IFIFO
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package notify reports changes to the files in directories.
+package notify
+
+// Watcher watches directories and sends the paths of the files that change
+// in them on Events.
+type Watcher struct {
+	Events chan string
+	Errors chan error
+
+	w watcher
+}
+
+// New returns a watcher that isn't watching anything.
+func New() (*Watcher, error) {
+	w := &Watcher{
+		Events: make(chan string),
+		Errors: make(chan error),
+	}
+	if err := w.w.init(w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Add watches the files in dir, it doesn't watch subdirectories.
+func (w *Watcher) Add(dir string) error {
+	return w.w.add(dir)
+}
+
+// Close stops watching, Events is closed once the watcher is done.
+func (w *Watcher) Close() error {
+	return w.w.close()
+}
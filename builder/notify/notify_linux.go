@@ -0,0 +1,98 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// changes are the inotify events that mean the contents of a file changed,
+// editors often write to a new file and rename it and git removes and
+// creates files.
+const changes = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_ATTRIB
+
+type watcher struct {
+	// fd is kept apart from f, calling Fd on f would make reads block
+	// when it's closed.
+	fd   int
+	f    *os.File
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+func (w *watcher) init(parent *Watcher) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("watching files: %s", err.Error())
+	}
+	w.fd = fd
+	w.f = os.NewFile(uintptr(fd), "inotify")
+	w.dirs = make(map[int32]string)
+	go w.read(parent)
+	return nil
+}
+
+func (w *watcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, changes)
+	if err != nil {
+		return fmt.Errorf("watching %s: %s", dir, err.Error())
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *watcher) read(parent *Watcher) {
+	defer close(parent.Events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				parent.Errors <- err
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(e.Len)]
+			off += syscall.SizeofInotifyEvent + int(e.Len)
+
+			w.mu.Lock()
+			dir, ok := w.dirs[e.Wd]
+			if e.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, e.Wd)
+			}
+			w.mu.Unlock()
+			if !ok || e.Mask&changes == 0 {
+				continue
+			}
+			path := dir
+			// names are padded with zeros.
+			for i, c := range name {
+				if c == 0 {
+					name = name[:i]
+					break
+				}
+			}
+			if len(name) > 0 {
+				path = filepath.Join(dir, string(name))
+			}
+			parent.Events <- path
+		}
+	}
+}
+
+func (w *watcher) close() error {
+	return w.f.Close()
+}
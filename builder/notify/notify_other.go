@@ -0,0 +1,24 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package notify
+
+import "fmt"
+
+type watcher struct{}
+
+func (w *watcher) init(parent *Watcher) error {
+	return fmt.Errorf("watching files isn't supported on this platform")
+}
+
+func (w *watcher) add(dir string) error {
+	return nil
+}
+
+func (w *watcher) close() error {
+	return nil
+}
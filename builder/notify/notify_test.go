@@ -0,0 +1,61 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching files isn't supported on " + runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// editors write to a temporary file and rename it.
+	tmp := filepath.Join(dir, ".a.c.swp")
+	if err := ioutil.WriteFile(tmp, []byte("int a;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "a.c")); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for found := false; !found; {
+		select {
+		case p := <-w.Events:
+			found = p == filepath.Join(dir, "a.c")
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatal("the change to a.c wasn't reported")
+		}
+	}
+
+	go func() {
+		for range w.Events {
+		}
+	}()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
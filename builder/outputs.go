@@ -0,0 +1,125 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/builder/install"
+	"bldy.build/build/cache"
+	"bldy.build/build/util"
+)
+
+// targetsDir is the directory in build_out the outputs of every target that
+// was built are linked in to.
+const targetsDir = "targets"
+
+// verify checks that the node produced every output it installs, and
+// warns about files it left in the output directory without declaring
+// them when the builder is set to.
+func (b *Builder) verify(n *Node, dir string) error {
+	var declared []string
+	for _, src := range n.Target.Installs() {
+		declared = append(declared, src)
+	}
+	if missing := missingOutputs(dir, declared); len(missing) > 0 {
+		return fmt.Errorf("%s didn't produce %s", n.Url.String(), strings.Join(missing, ", "))
+	}
+	if !b.WarnUndeclared {
+		return nil
+	}
+	// the outputs of dependencies and the files actions declare they
+	// write aren't outputs of the node, but they aren't undeclared.
+	for _, c := range n.Children {
+		if c.result == nil {
+			continue
+		}
+		for dst := range c.result.Outputs {
+			declared = append(declared, dst)
+		}
+	}
+	if p, ok := n.Target.(build.Planner); ok {
+		for _, a := range p.Actions() {
+			declared = append(declared, a.Outputs...)
+		}
+	}
+	if files := undeclaredOutputs(dir, declared); len(files) > 0 {
+		log.Printf("%s left undeclared files in its output directory: %s", n.Url.String(), strings.Join(files, ", "))
+	}
+	return nil
+}
+
+// missingOutputs returns the paths, relative to dir, that don't exist.
+func missingOutputs(dir string, paths []string) (missing []string) {
+	for _, p := range paths {
+		if _, err := os.Lstat(filepath.Join(dir, p)); err != nil {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// undeclaredOutputs returns the files in dir that aren't one of the
+// declared paths or in one of them.
+func undeclaredOutputs(dir string, declared []string) (files []string) {
+	isDeclared := make(map[string]bool)
+	for _, p := range declared {
+		isDeclared[filepath.Clean(p)] = true
+	}
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		if isDeclared[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+// linkTargets links the outputs of every node that was built in to
+// build_out/targets/<package>/<target>, so they can be found without
+// knowing their hashes. The links of nodes that failed are left as they
+// were. Outputs are hard linked, so they outlive the blobs being collected,
+// and copied where they can't be.
+func (b *Builder) linkTargets() error {
+	in, err := install.New(filepath.Join(util.BuildOut(), targetsDir), install.Hardlink, false)
+	if err != nil {
+		return err
+	}
+	in.Force = true
+	for url, n := range b.Nodes {
+		if n.result == nil || !n.result.Success {
+			continue
+		}
+		dir := filepath.Join(n.Url.Package, n.Url.Target)
+		outputs := make(map[string]cache.Output)
+		for dst, o := range n.result.Outputs {
+			outputs[filepath.Join(dir, dst)] = o
+		}
+		if _, err := in.Install(url, outputs); err != nil {
+			return err
+		}
+	}
+	return in.Commit()
+}
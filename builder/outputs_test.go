@@ -0,0 +1,89 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"bldy.build/build/cache"
+	"bldy.build/build/parser"
+)
+
+func TestOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"libfoo.a", "foo.o", "include/foo.h", "include/bar/bar.h", "dep/libbar.a"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := missingOutputs(dir, []string{"libfoo.a", "include", "libfoo.so", "foo.pc"})
+	if expected := []string{"foo.pc", "libfoo.so"}; !reflect.DeepEqual(missing, expected) {
+		t.Errorf("got missing outputs %q, expected %q", missing, expected)
+	}
+
+	files := undeclaredOutputs(dir, []string{"libfoo.a", "include", "dep/libbar.a"})
+	if expected := []string{"foo.o"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("got undeclared outputs %q, expected %q", files, expected)
+	}
+}
+
+func TestLinkTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("BUILD_CACHE", filepath.Join(dir, "cache"))
+	defer os.Unsetenv("BUILD_CACHE")
+	os.Setenv("BUILD_OUT", filepath.Join(dir, "build_out"))
+	defer os.Unsetenv("BUILD_OUT")
+
+	lib := filepath.Join(dir, "libc.a")
+	if err := ioutil.WriteFile(lib, []byte("!<arch>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o, err := cache.Put(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libc := newTestNode("//sys/src/libc:libc")
+	libc.Url = parser.NewTargetURLFromString(libc.Type)
+	libc.result = &cache.Manifest{Success: true, Outputs: map[string]cache.Output{"lib/libc.a": o}}
+	failed := newTestNode("//sys/src/cmd:cat")
+	failed.Url = parser.NewTargetURLFromString(failed.Type)
+	failed.result = &cache.Manifest{Success: false}
+	b := &Builder{Nodes: map[string]*Node{
+		libc.Url.String():   libc,
+		failed.Url.String(): failed,
+	}}
+	if err := b.linkTargets(); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "build_out", "targets", "sys/src/libc/libc/lib/libc.a")
+	// the outputs are still there once the cache is collected.
+	if err := os.RemoveAll(filepath.Join(dir, "cache")); err != nil {
+		t.Fatal(err)
+	}
+	if bytz, err := ioutil.ReadFile(link); err != nil || string(bytz) != "!<arch>\n" {
+		t.Errorf("got %q, expected the contents of libc.a: %v", bytz, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build_out", "targets", "sys/src/cmd")); !os.IsNotExist(err) {
+		t.Errorf("the outputs of a target that failed were linked")
+	}
+}
@@ -4,50 +4,18 @@
 
 package builder
 
-import (
-	"container/heap"
-	"sync"
-)
-
-type p struct {
-	q *PriorityQueue
-	c *sync.Cond
-}
-
-func newP() *p {
-	q := PriorityQueue([]*Node{})
-	return &p{
-		q: &q,
-		c: sync.NewCond(&sync.Mutex{}),
-	}
-}
-func (p *p) len() int {
-	return p.q.Len()
-}
-func (p *p) push(n *Node) {
-	p.c.L.Lock()
-
-	heap.Push(p.q, n)
-	p.c.Signal()
-	p.c.L.Unlock()
-
-}
-func (p *p) pop() *Node {
-	p.c.L.Lock()
-	if p.q.Len() == 0 {
-		p.c.Wait()
-	}
-	x := heap.Pop(p.q)
-	p.c.L.Unlock()
-	return x.(*Node)
-}
-
 type PriorityQueue []*Node
 
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
 	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
+	// Nodes on the longest paths to the root are built first, nodes that
+	// we don't know the timings of are ranked by how many nodes depend
+	// on them.
+	if pq[i].Critical != pq[j].Critical {
+		return pq[i].Critical > pq[j].Critical
+	}
 	return pq[i].Priority > pq[j].Priority
 }
 
@@ -0,0 +1,234 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// span is a period of time spent on one thing during a build.
+type span struct {
+	name, cat  string
+	lane       int
+	start, end time.Time
+	args       traceArgs
+}
+
+// lanes of the profile, workers get a lane each after main.
+const (
+	mainLane = iota
+	firstWorkerLane
+)
+
+// traceEvent is an event in the Chrome Trace Event format, times are in
+// microseconds.
+type traceEvent struct {
+	Name  string    `json:"name"`
+	Cat   string    `json:"cat,omitempty"`
+	Ph    string    `json:"ph"`
+	Ts    float64   `json:"ts"`
+	Dur   float64   `json:"dur,omitempty"`
+	Pid   int       `json:"pid"`
+	Tid   int       `json:"tid"`
+	Cname string    `json:"cname,omitempty"`
+	Args  traceArgs `json:"args"`
+}
+
+type traceArgs struct {
+	// Name is the name of the lane in metadata events.
+	Name   string   `json:"name,omitempty"`
+	Target string   `json:"target,omitempty"`
+	Cached bool     `json:"cached,omitempty"`
+	Failed bool     `json:"failed,omitempty"`
+	Deps   []string `json:"deps,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+func (b *Builder) record(s span) {
+	b.spansMu.Lock()
+	b.spans = append(b.spans, s)
+	b.spansMu.Unlock()
+}
+
+// recordTarget records the time a worker spent on a node.
+func (b *Builder) recordTarget(n *Node, worker int, start time.Time, failed bool) {
+	s := span{
+		name:  n.Url.String(),
+		cat:   "build",
+		lane:  firstWorkerLane + worker,
+		start: start,
+		end:   time.Now(),
+		args: traceArgs{
+			Target: n.Url.String(),
+			Cached: n.Cached,
+			Failed: failed,
+		},
+	}
+	if n.Cached {
+		s.cat = "cached"
+	}
+	for _, c := range n.Children {
+		s.args.Deps = append(s.args.Deps, c.Url.String())
+	}
+	sort.Strings(s.args.Deps)
+	b.record(s)
+}
+
+// WriteProfile writes the time spent loading the graph, evaluating BUILD
+// files and building every target in the Chrome Trace Event format, which
+// can be viewed in chrome://tracing.
+func (b *Builder) WriteProfile(w io.Writer) error {
+	b.spansMu.Lock()
+	spans := append([]span{}, b.spans...)
+	b.spansMu.Unlock()
+	if len(spans) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+
+	origin := spans[0].start
+	lanes := make(map[int]bool)
+	for _, s := range spans {
+		if s.start.Before(origin) {
+			origin = s.start
+		}
+		lanes[s.lane] = true
+	}
+	micros := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+
+	var f traceFile
+	f.DisplayTimeUnit = "ms"
+	for lane := range lanes {
+		name := "main"
+		if lane >= firstWorkerLane {
+			name = fmt.Sprintf("worker %d", lane-firstWorkerLane)
+		}
+		f.TraceEvents = append(f.TraceEvents, traceEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Tid:  lane,
+			Args: traceArgs{Name: name},
+		})
+	}
+	for _, s := range spans {
+		e := traceEvent{
+			Name: s.name,
+			Cat:  s.cat,
+			Ph:   "X",
+			Ts:   micros(s.start.Sub(origin)),
+			Dur:  micros(s.end.Sub(s.start)),
+			Tid:  s.lane,
+			Args: s.args,
+		}
+		switch {
+		case s.args.Failed:
+			e.Cname = "terrible"
+		case s.args.Cached:
+			e.Cname = "good"
+		}
+		f.TraceEvents = append(f.TraceEvents, e)
+	}
+	return json.NewEncoder(w).Encode(f)
+}
+
+// TargetTime is the time it took to build a target.
+type TargetTime struct {
+	Target   string
+	Duration time.Duration
+	Cached   bool
+}
+
+// Analysis is a summary of a profile.
+type Analysis struct {
+	// CriticalPath is the chain of dependencies that took the longest
+	// to build, starting from the first target that was built.
+	CriticalPath []TargetTime
+	// CriticalTime is the sum of the times of the targets on the
+	// critical path.
+	CriticalTime time.Duration
+	// Targets are all the targets in the profile, slowest first.
+	Targets []TargetTime
+}
+
+// AnalyzeProfile reads a profile written by WriteProfile.
+func AnalyzeProfile(r io.Reader) (*Analysis, error) {
+	var f traceFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]TargetTime)
+	deps := make(map[string][]string)
+	for _, e := range f.TraceEvents {
+		if e.Ph != "X" || e.Args.Target == "" {
+			continue
+		}
+		targets[e.Args.Target] = TargetTime{
+			Target:   e.Args.Target,
+			Duration: time.Duration(e.Dur * float64(time.Microsecond)),
+			Cached:   e.Args.Cached,
+		}
+		deps[e.Args.Target] = e.Args.Deps
+	}
+
+	var a Analysis
+	for _, t := range targets {
+		a.Targets = append(a.Targets, t)
+	}
+	sort.Sort(bySlowest(a.Targets))
+
+	// the longest time it took to build each target along with its
+	// dependencies, and the dependency it was waiting on.
+	cost := make(map[string]time.Duration)
+	next := make(map[string]string)
+	var longest func(t string) time.Duration
+	longest = func(t string) time.Duration {
+		if c, ok := cost[t]; ok {
+			return c
+		}
+		cost[t] = 0
+		var max time.Duration
+		for _, d := range deps[t] {
+			if c := longest(d); c > max || next[t] == "" {
+				max = c
+				next[t] = d
+			}
+		}
+		cost[t] = targets[t].Duration + max
+		return cost[t]
+	}
+	end := ""
+	for _, t := range a.Targets {
+		if c := longest(t.Target); end == "" || c > cost[end] {
+			end = t.Target
+		}
+	}
+	for t := end; t != ""; t = next[t] {
+		if tt, ok := targets[t]; ok {
+			a.CriticalPath = append([]TargetTime{tt}, a.CriticalPath...)
+		}
+	}
+	a.CriticalTime = cost[end]
+	return &a, nil
+}
+
+type bySlowest []TargetTime
+
+func (a bySlowest) Len() int      { return len(a) }
+func (a bySlowest) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySlowest) Less(i, j int) bool {
+	if a[i].Duration == a[j].Duration {
+		return a[i].Target < a[j].Target
+	}
+	return a[i].Duration > a[j].Duration
+}
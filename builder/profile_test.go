@@ -0,0 +1,124 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// profileTarget is a target in a test profile, it took dur to build.
+type profileTarget struct {
+	name string
+	dur  time.Duration
+	deps []string
+}
+
+var analyzeTests = []struct {
+	name     string
+	targets  []profileTarget
+	path     []string
+	critical time.Duration
+	slowest  []string
+}{
+	{
+		name: "single",
+		targets: []profileTarget{
+			{"//:a", time.Second, nil},
+		},
+		path:     []string{"//:a"},
+		critical: time.Second,
+		slowest:  []string{"//:a"},
+	},
+	{
+		// b and c take 3s, d has 500ms of slack.
+		name: "diamond",
+		targets: []profileTarget{
+			{"//:a", time.Second, nil},
+			{"//:b", 2 * time.Second, []string{"//:a"}},
+			{"//:c", time.Second, []string{"//:b"}},
+			{"//:d", 2500 * time.Millisecond, []string{"//:a"}},
+			{"//:e", time.Second, []string{"//:c", "//:d"}},
+		},
+		path:     []string{"//:a", "//:b", "//:c", "//:e"},
+		critical: 5 * time.Second,
+		slowest:  []string{"//:d", "//:b", "//:a", "//:c", "//:e"},
+	},
+	{
+		// the slowest target isn't on the longest chain, b and c have
+		// 500ms of slack.
+		name: "slowest off the path",
+		targets: []profileTarget{
+			{"//:a", time.Second, nil},
+			{"//:b", 2 * time.Second, []string{"//:a"}},
+			{"//:c", time.Second, []string{"//:b"}},
+			{"//:d", 3500 * time.Millisecond, []string{"//:a"}},
+			{"//:e", time.Second, []string{"//:c", "//:d"}},
+		},
+		path:     []string{"//:a", "//:d", "//:e"},
+		critical: 5500 * time.Millisecond,
+		slowest:  []string{"//:d", "//:b", "//:a", "//:c", "//:e"},
+	},
+	{
+		// nothing depends on each other, the slowest target is the
+		// path and the rest have slack.
+		name: "independent",
+		targets: []profileTarget{
+			{"//:x", 2 * time.Second, nil},
+			{"//:y", 3 * time.Second, nil},
+			{"//:z", time.Second, nil},
+		},
+		path:     []string{"//:y"},
+		critical: 3 * time.Second,
+		slowest:  []string{"//:y", "//:x", "//:z"},
+	},
+}
+
+func TestAnalyzeProfile(t *testing.T) {
+	for _, test := range analyzeTests {
+		b := &Builder{}
+		start := time.Now()
+		for i, pt := range test.targets {
+			b.record(span{
+				name:  pt.name,
+				cat:   "build",
+				lane:  firstWorkerLane + i,
+				start: start,
+				end:   start.Add(pt.dur),
+				args:  traceArgs{Target: pt.name, Deps: pt.deps},
+			})
+		}
+		// spans that aren't targets are left out.
+		b.record(span{name: "load", lane: mainLane, start: start, end: start.Add(time.Hour)})
+
+		var buf bytes.Buffer
+		if err := b.WriteProfile(&buf); err != nil {
+			t.Fatal(err)
+		}
+		a, err := AnalyzeProfile(&buf)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		var path, slowest []string
+		for _, tt := range a.CriticalPath {
+			path = append(path, tt.Target)
+		}
+		for _, tt := range a.Targets {
+			slowest = append(slowest, tt.Target)
+		}
+		if !reflect.DeepEqual(path, test.path) {
+			t.Errorf("%s: expected the critical path %v got %v", test.name, test.path, path)
+		}
+		if a.CriticalTime != test.critical {
+			t.Errorf("%s: expected the critical time %s got %s", test.name, test.critical, a.CriticalTime)
+		}
+		if !reflect.DeepEqual(slowest, test.slowest) {
+			t.Errorf("%s: expected the slowest targets %v got %v", test.name, test.slowest, slowest)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package remote executes commands on servers that implement the Remote
+// Execution API v2.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// blobs larger than this are uploaded with the byte stream API
+	// instead of being batched.
+	maxBatchSize = 2 << 20
+	chunkSize    = 1 << 20
+
+	// workDir is the directory in the input root commands are run in,
+	// srcDir is where files from the project are placed.
+	workDir = "out"
+	srcDir  = "src"
+)
+
+// Executor runs commands on a remote execution server. The directory a
+// command runs in is uploaded as the input root, along with the files in
+// the project the command refers to, and everything in the directory is
+// downloaded after the command completes.
+type Executor struct {
+	Instance    string
+	ProjectPath string
+
+	conn *grpc.ClientConn
+	cas  repb.ContentAddressableStorageClient
+	exec repb.ExecutionClient
+	bs   bytestream.ByteStreamClient
+}
+
+// New connects to the remote execution server at target.
+func New(target, instance, projectPath string) (*Executor, error) {
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &Executor{
+		Instance:    instance,
+		ProjectPath: strings.TrimSuffix(projectPath, "/"),
+		conn:        conn,
+		cas:         repb.NewContentAddressableStorageClient(conn),
+		exec:        repb.NewExecutionClient(conn),
+		bs:          bytestream.NewByteStreamClient(conn),
+	}, nil
+}
+
+// Close closes the connection to the server.
+func (e *Executor) Close() error {
+	return e.conn.Close()
+}
+
+// Exec runs cmd on the remote server as if it was run in dir.
+func (e *Executor) Exec(ctx context.Context, dir string, stdout, stderr io.Writer, cmd string, env, params []string) error {
+	in := newTree()
+	if err := in.addDir(workDir, dir, true); err != nil {
+		return err
+	}
+
+	arg, err := e.rewrite(in, cmd)
+	if err != nil {
+		return err
+	}
+	args := []string{arg}
+	for _, p := range params {
+		if arg, err = e.rewrite(in, p); err != nil {
+			return err
+		}
+		args = append(args, arg)
+	}
+	command := &repb.Command{
+		Arguments:        args,
+		WorkingDirectory: workDir,
+		// capture the whole working directory, outputs of targets
+		// aren't known before they are built.
+		OutputDirectories: []string{""},
+	}
+	// like os/exec, the last value of duplicate variables wins.
+	vars := make(map[string]string)
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	for k, v := range vars {
+		value, err := e.rewrite(in, v)
+		if err != nil {
+			return err
+		}
+		command.EnvironmentVariables = append(command.EnvironmentVariables, &repb.Command_EnvironmentVariable{
+			Name:  k,
+			Value: value,
+		})
+	}
+	sort.Sort(byName(command.EnvironmentVariables))
+
+	root, err := in.root()
+	if err != nil {
+		return err
+	}
+	commandDigest, err := in.addMessage(command)
+	if err != nil {
+		return err
+	}
+	actionDigest, err := in.addMessage(&repb.Action{
+		CommandDigest:   commandDigest,
+		InputRootDigest: root,
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.upload(ctx, in.blobs); err != nil {
+		return fmt.Errorf("uploading inputs: %s", err.Error())
+	}
+
+	result, err := e.execute(ctx, actionDigest)
+	if err != nil {
+		return err
+	}
+	if err := e.writeOutput(ctx, stdout, result.StdoutRaw, result.StdoutDigest); err != nil {
+		return err
+	}
+	if err := e.writeOutput(ctx, stderr, result.StderrRaw, result.StderrDigest); err != nil {
+		return err
+	}
+	if err := e.download(ctx, dir, result); err != nil {
+		return fmt.Errorf("downloading outputs: %s", err.Error())
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return nil
+}
+
+// rewrite replaces the project paths in s with paths relative to the
+// working directory and adds the files they refer to to the inputs. If a
+// file is referred to, the rest of the files in it's directory are added
+// too since they may be headers it includes.
+func (e *Executor) rewrite(in *tree, s string) (string, error) {
+	if e.ProjectPath == "" {
+		return s, nil
+	}
+	for rest := s; ; {
+		i := strings.Index(rest, e.ProjectPath)
+		if i < 0 {
+			break
+		}
+		rest = rest[i:]
+		end := strings.IndexAny(rest, " \t\n'\";:,=()<>|&")
+		if end < 0 {
+			end = len(rest)
+		}
+		local := rest[:end]
+		rest = rest[end:]
+		rel, err := filepath.Rel(e.ProjectPath, local)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		stat, err := os.Stat(local)
+		if err != nil {
+			continue
+		}
+		if stat.IsDir() {
+			err = in.addDir(path.Join(srcDir, filepath.ToSlash(rel)), local, true)
+		} else {
+			err = in.addDir(path.Join(srcDir, filepath.ToSlash(filepath.Dir(rel))), filepath.Dir(local), false)
+		}
+		if err != nil {
+			return "", fmt.Errorf("adding %s to the inputs: %s", local, err.Error())
+		}
+	}
+	return strings.Replace(s, e.ProjectPath, path.Join("..", srcDir), -1), nil
+}
+
+func (e *Executor) execute(ctx context.Context, action *repb.Digest) (*repb.ActionResult, error) {
+	stream, err := e.exec.Execute(ctx, &repb.ExecuteRequest{
+		InstanceName: e.Instance,
+		ActionDigest: action,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("executing: %s", err.Error())
+		}
+		if !op.Done {
+			continue
+		}
+		if opErr := op.GetError(); opErr != nil {
+			return nil, fmt.Errorf("executing: %s", opErr.Message)
+		}
+		var resp repb.ExecuteResponse
+		if err := op.GetResponse().UnmarshalTo(&resp); err != nil {
+			return nil, err
+		}
+		if s := resp.Status; s != nil && s.Code != 0 {
+			return nil, fmt.Errorf("executing: %s", s.Message)
+		}
+		if resp.Result == nil {
+			return nil, fmt.Errorf("executing: server returned no result")
+		}
+		return resp.Result, nil
+	}
+}
+
+func (e *Executor) writeOutput(ctx context.Context, w io.Writer, raw []byte, d *repb.Digest) error {
+	if len(raw) > 0 || d == nil || d.SizeBytes == 0 {
+		_, err := w.Write(raw)
+		return err
+	}
+	bytz, err := e.read(ctx, d)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytz)
+	return err
+}
+
+// upload uploads the blobs the server is missing.
+func (e *Executor) upload(ctx context.Context, blobs map[string]*blob) error {
+	req := &repb.FindMissingBlobsRequest{InstanceName: e.Instance}
+	for _, b := range blobs {
+		req.BlobDigests = append(req.BlobDigests, b.digest)
+	}
+	resp, err := e.cas.FindMissingBlobs(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	batch := &repb.BatchUpdateBlobsRequest{InstanceName: e.Instance}
+	size := int64(0)
+	flush := func() error {
+		if len(batch.Requests) == 0 {
+			return nil
+		}
+		resp, err := e.cas.BatchUpdateBlobs(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, r := range resp.Responses {
+			if r.Status != nil && r.Status.Code != 0 {
+				return fmt.Errorf("uploading %s: %s", r.Digest.Hash, r.Status.Message)
+			}
+		}
+		batch = &repb.BatchUpdateBlobsRequest{InstanceName: e.Instance}
+		size = 0
+		return nil
+	}
+	for _, d := range resp.MissingBlobDigests {
+		b := blobs[d.Hash]
+		if d.SizeBytes > maxBatchSize {
+			if err := e.write(ctx, b); err != nil {
+				return err
+			}
+			continue
+		}
+		if size+d.SizeBytes > maxBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		data, err := b.read()
+		if err != nil {
+			return err
+		}
+		batch.Requests = append(batch.Requests, &repb.BatchUpdateBlobsRequest_Request{
+			Digest: b.digest,
+			Data:   data,
+		})
+		size += d.SizeBytes
+	}
+	return flush()
+}
+
+// write uploads a large blob with the byte stream API.
+func (e *Executor) write(ctx context.Context, b *blob) error {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("uploads/%x-%x-%x-%x-%x/blobs/%s/%d", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:], b.digest.Hash, b.digest.SizeBytes)
+	if e.Instance != "" {
+		name = path.Join(e.Instance, name)
+	}
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stream, err := e.bs.Write(ctx)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	offset := int64(0)
+	for {
+		n, err := f.Read(buf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := offset+int64(n) >= b.digest.SizeBytes
+		if err := stream.Send(&bytestream.WriteRequest{
+			ResourceName: name,
+			WriteOffset:  offset,
+			FinishWrite:  last,
+			Data:         buf[:n],
+		}); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if last {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// read downloads a blob with the byte stream API.
+func (e *Executor) read(ctx context.Context, d *repb.Digest) ([]byte, error) {
+	if d.SizeBytes == 0 {
+		return nil, nil
+	}
+	name := fmt.Sprintf("blobs/%s/%d", d.Hash, d.SizeBytes)
+	if e.Instance != "" {
+		name = path.Join(e.Instance, name)
+	}
+	stream, err := e.bs.Read(ctx, &bytestream.ReadRequest{ResourceName: name})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		buf.Write(resp.Data)
+	}
+	if digest := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())); digest != d.Hash {
+		return nil, fmt.Errorf("blob %s has digest %s", d.Hash, digest)
+	}
+	return buf.Bytes(), nil
+}
+
+// join joins a path the server returned to dir, paths from the server
+// can't be trusted to stay in it.
+func join(dir, p string) (string, error) {
+	clean := path.Clean(p)
+	if path.IsAbs(p) || filepath.IsAbs(filepath.FromSlash(p)) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("output %q isn't in the working directory", p)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// checkName returns an error if the name of a file or directory in an
+// output tree isn't a single path element.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/"+string(filepath.Separator)) {
+		return fmt.Errorf("output tree has a file named %q", name)
+	}
+	return nil
+}
+
+// download writes the outputs of the action in to dir.
+func (e *Executor) download(ctx context.Context, dir string, result *repb.ActionResult) error {
+	for _, f := range result.OutputFiles {
+		p, err := join(dir, f.Path)
+		if err != nil {
+			return err
+		}
+		if err := e.downloadFile(ctx, p, f.Digest, f.IsExecutable); err != nil {
+			return err
+		}
+	}
+	for _, od := range result.OutputDirectories {
+		p, err := join(dir, od.Path)
+		if err != nil {
+			return err
+		}
+		bytz, err := e.read(ctx, od.TreeDigest)
+		if err != nil {
+			return err
+		}
+		var t repb.Tree
+		if err := proto.Unmarshal(bytz, &t); err != nil {
+			return err
+		}
+		children := make(map[string]*repb.Directory)
+		for _, c := range t.Children {
+			d, _, err := digestMessage(c)
+			if err != nil {
+				return err
+			}
+			children[d.Hash] = c
+		}
+		if err := e.downloadDir(ctx, p, t.Root, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Executor) downloadDir(ctx context.Context, dir string, d *repb.Directory, children map[string]*repb.Directory) error {
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	for _, f := range d.Files {
+		if err := checkName(f.Name); err != nil {
+			return err
+		}
+		if err := e.downloadFile(ctx, filepath.Join(dir, f.Name), f.Digest, f.IsExecutable); err != nil {
+			return err
+		}
+	}
+	for _, sub := range d.Directories {
+		if err := checkName(sub.Name); err != nil {
+			return err
+		}
+		c, ok := children[sub.Digest.Hash]
+		if !ok {
+			return fmt.Errorf("directory %s is missing from the output tree", sub.Name)
+		}
+		if err := e.downloadDir(ctx, filepath.Join(dir, sub.Name), c, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadFile writes the blob to path, unless path already has the same
+// contents. Files in the working directory may be hard links to the
+// cache, so they are replaced instead of being written to.
+func (e *Executor) downloadFile(ctx context.Context, p string, d *repb.Digest, executable bool) error {
+	if stat, err := os.Stat(p); err == nil && stat.Size() == d.SizeBytes {
+		if local, err := digestFile(p); err == nil && local.Hash == d.Hash {
+			return nil
+		}
+	}
+	bytz, err := e.read(ctx, d)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	os.Remove(p)
+	var mode os.FileMode = 0644
+	if executable {
+		mode = 0755
+	}
+	return ioutil.WriteFile(p, bytz, mode)
+}
+
+type byName []*repb.Command_EnvironmentVariable
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
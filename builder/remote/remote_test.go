@@ -0,0 +1,377 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// server is a fake execution server that runs actions on the local machine.
+type server struct {
+	repb.UnimplementedExecutionServer
+	repb.UnimplementedContentAddressableStorageServer
+	bytestream.UnimplementedByteStreamServer
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+
+	// result, if set, changes the results of actions before they are sent.
+	result func(*repb.ActionResult)
+}
+
+func (s *server) get(d *repb.Digest) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[d.Hash]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", d.Hash)
+	}
+	return b, nil
+}
+
+func (s *server) put(b []byte) *repb.Digest {
+	d := &repb.Digest{Hash: fmt.Sprintf("%x", sha256.Sum256(b)), SizeBytes: int64(len(b))}
+	s.mu.Lock()
+	s.blobs[d.Hash] = b
+	s.mu.Unlock()
+	return d
+}
+
+func (s *server) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	var resp repb.FindMissingBlobsResponse
+	for _, d := range req.BlobDigests {
+		if _, err := s.get(d); err != nil {
+			resp.MissingBlobDigests = append(resp.MissingBlobDigests, d)
+		}
+	}
+	return &resp, nil
+}
+
+func (s *server) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	var resp repb.BatchUpdateBlobsResponse
+	for _, r := range req.Requests {
+		s.put(r.Data)
+		resp.Responses = append(resp.Responses, &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest})
+	}
+	return &resp, nil
+}
+
+func (s *server) Read(req *bytestream.ReadRequest, stream bytestream.ByteStream_ReadServer) error {
+	parts := strings.Split(req.ResourceName, "/")
+	b, err := s.get(&repb.Digest{Hash: parts[len(parts)-2]})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&bytestream.ReadResponse{Data: b})
+}
+
+func (s *server) Write(stream bytestream.ByteStream_WriteServer) error {
+	var buf bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		buf.Write(req.Data)
+	}
+	s.put(buf.Bytes())
+	return stream.SendAndClose(&bytestream.WriteResponse{CommittedSize: int64(buf.Len())})
+}
+
+func (s *server) unmarshal(d *repb.Digest, m proto.Message) error {
+	b, err := s.get(d)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, m)
+}
+
+func (s *server) Execute(req *repb.ExecuteRequest, stream repb.Execution_ExecuteServer) error {
+	var action repb.Action
+	if err := s.unmarshal(req.ActionDigest, &action); err != nil {
+		return err
+	}
+	var command repb.Command
+	if err := s.unmarshal(action.CommandDigest, &command); err != nil {
+		return err
+	}
+	root, err := ioutil.TempDir("", "execroot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	if err := s.materialize(root, action.InputRootDigest); err != nil {
+		return err
+	}
+
+	wd := filepath.Join(root, command.WorkingDirectory)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command.Arguments[0], command.Arguments[1:]...)
+	cmd.Dir = wd
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	for _, v := range command.EnvironmentVariables {
+		cmd.Env = append(cmd.Env, v.Name+"="+v.Value)
+	}
+	result := &repb.ActionResult{}
+	if err := cmd.Run(); err != nil {
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
+		}
+		result.ExitCode = int32(exit.ExitCode())
+	}
+	result.StdoutRaw = stdout.Bytes()
+	result.StdoutDigest = s.put(stdout.Bytes())
+	result.StderrDigest = s.put(stderr.Bytes())
+
+	for _, p := range command.OutputDirectories {
+		var t repb.Tree
+		if t.Root, err = s.directory(filepath.Join(wd, p), &t); err != nil {
+			return err
+		}
+		b, err := proto.Marshal(&t)
+		if err != nil {
+			return err
+		}
+		result.OutputDirectories = append(result.OutputDirectories, &repb.OutputDirectory{
+			Path:       p,
+			TreeDigest: s.put(b),
+		})
+	}
+
+	if s.result != nil {
+		s.result(result)
+	}
+	resp, err := anypb.New(&repb.ExecuteResponse{Result: result})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&longrunningpb.Operation{
+		Name:   "execute",
+		Done:   true,
+		Result: &longrunningpb.Operation_Response{Response: resp},
+	})
+}
+
+func (s *server) materialize(dir string, d *repb.Digest) error {
+	var directory repb.Directory
+	if err := s.unmarshal(d, &directory); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range directory.Files {
+		b, err := s.get(f.Digest)
+		if err != nil {
+			return err
+		}
+		var mode os.FileMode = 0644
+		if f.IsExecutable {
+			mode = 0755
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, f.Name), b, mode); err != nil {
+			return err
+		}
+	}
+	for _, sub := range directory.Directories {
+		if err := s.materialize(filepath.Join(dir, sub.Name), sub.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) directory(dir string, t *repb.Tree) (*repb.Directory, error) {
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var d repb.Directory
+	for _, f := range fs {
+		p := filepath.Join(dir, f.Name())
+		if f.IsDir() {
+			child, err := s.directory(p, t)
+			if err != nil {
+				return nil, err
+			}
+			t.Children = append(t.Children, child)
+			cd, _, err := digestMessage(child)
+			if err != nil {
+				return nil, err
+			}
+			d.Directories = append(d.Directories, &repb.DirectoryNode{Name: f.Name(), Digest: cd})
+			continue
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		d.Files = append(d.Files, &repb.FileNode{
+			Name:         f.Name(),
+			Digest:       s.put(b),
+			IsExecutable: f.Mode()&0111 != 0,
+		})
+	}
+	return &d, nil
+}
+
+func testExecutor(t *testing.T, project string) *Executor {
+	return newTestExecutor(t, project, &server{blobs: make(map[string][]byte)})
+}
+
+func newTestExecutor(t *testing.T, project string, s *server) *Executor {
+	g := grpc.NewServer()
+	repb.RegisterExecutionServer(g, s)
+	repb.RegisterContentAddressableStorageServer(g, s)
+	bytestream.RegisterByteStreamServer(g, s)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+
+	e, err := New(l.Addr().String(), "", project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestExec(t *testing.T) {
+	project, err := ioutil.TempDir("", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(project)
+	dir, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(project, "in.txt")
+	if err := ioutil.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// larger than a batch, so it is sent with the byte stream api.
+	if err := ioutil.WriteFile(filepath.Join(dir, "big"), make([]byte, maxBatchSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := testExecutor(t, project)
+	var stdout, stderr bytes.Buffer
+	err = e.Exec(context.Background(), dir, &stdout, &stderr, "sh", []string{"GREETING=hi", "GREETING=hey"}, []string{
+		"-c",
+		fmt.Sprintf("tr a-z A-Z < %s > out.txt && wc -c < big && echo $GREETING", src),
+	})
+	if err != nil {
+		t.Fatalf("%s: %s", err, stderr.String())
+	}
+	if got, want := stdout.String(), fmt.Sprintf("%d\nhey\n", maxBatchSize+1); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	bytz, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytz) != "HELLO" {
+		t.Errorf("out.txt = %q, want %q", bytz, "HELLO")
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := testExecutor(t, "")
+	var stdout, stderr bytes.Buffer
+	err = e.Exec(context.Background(), dir, &stdout, &stderr, "sh", nil, []string{"-c", "echo broken >&2; exit 3"})
+	if err == nil || err.Error() != "exit status 3" {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if stderr.String() != "broken\n" {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
+
+func TestExecRejectsEscapingOutputs(t *testing.T) {
+	parent, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	s := &server{blobs: make(map[string][]byte)}
+	tree := func(name string) *repb.Digest {
+		b, err := proto.Marshal(&repb.Tree{Root: &repb.Directory{
+			Files: []*repb.FileNode{{Name: name, Digest: s.put([]byte("x"))}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.put(b)
+	}
+	tests := []struct {
+		name   string
+		result func(*repb.ActionResult)
+	}{
+		{"relative file", func(r *repb.ActionResult) {
+			r.OutputFiles = append(r.OutputFiles, &repb.OutputFile{Path: "../escaped", Digest: s.put([]byte("x"))})
+		}},
+		{"absolute file", func(r *repb.ActionResult) {
+			r.OutputFiles = append(r.OutputFiles, &repb.OutputFile{Path: filepath.Join(parent, "escaped"), Digest: s.put([]byte("x"))})
+		}},
+		{"directory", func(r *repb.ActionResult) {
+			r.OutputDirectories = append(r.OutputDirectories, &repb.OutputDirectory{Path: "../escaped", TreeDigest: tree("x")})
+		}},
+		{"file in a tree", func(r *repb.ActionResult) {
+			r.OutputDirectories = append(r.OutputDirectories, &repb.OutputDirectory{Path: "", TreeDigest: tree("../escaped")})
+		}},
+	}
+	e := newTestExecutor(t, "", s)
+	for _, test := range tests {
+		dir := filepath.Join(parent, "out")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		s.result = test.result
+		var stdout, stderr bytes.Buffer
+		if err := e.Exec(context.Background(), dir, &stdout, &stderr, "true", nil, nil); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if _, err := os.Stat(filepath.Join(parent, "escaped")); !os.IsNotExist(err) {
+			t.Errorf("%s: the output was written outside the working directory", test.name)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// blob is either a file on disk or a serialized message.
+type blob struct {
+	digest *repb.Digest
+	path   string
+	data   []byte
+}
+
+func (b *blob) read() ([]byte, error) {
+	if b.data != nil {
+		return b.data, nil
+	}
+	return ioutil.ReadFile(b.path)
+}
+
+type file struct {
+	digest     *repb.Digest
+	executable bool
+}
+
+// tree is an input root being assembled from local files.
+type tree struct {
+	files map[string]file
+	dirs  map[string]bool
+	blobs map[string]*blob
+}
+
+func newTree() *tree {
+	return &tree{
+		files: make(map[string]file),
+		dirs:  map[string]bool{"": true},
+		blobs: make(map[string]*blob),
+	}
+}
+
+// addDir adds the files in the local directory to the tree under p, and
+// the files in it's subdirectories if recursive is set.
+func (t *tree) addDir(p, local string, recursive bool) error {
+	t.mkdir(p)
+	fs, err := ioutil.ReadDir(local)
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		lp := filepath.Join(local, f.Name())
+		// follow symbolic links, the server gets what they point to.
+		if f.Mode()&os.ModeSymlink != 0 {
+			if f, err = os.Stat(lp); err != nil {
+				continue
+			}
+		}
+		switch {
+		case f.IsDir() && recursive:
+			if err := t.addDir(path.Join(p, f.Name()), lp, true); err != nil {
+				return err
+			}
+		case f.Mode().IsRegular():
+			if err := t.addFile(path.Join(p, f.Name()), lp, f.Mode()&0111 != 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tree) addFile(p, local string, executable bool) error {
+	if _, ok := t.files[p]; ok {
+		return nil
+	}
+	d, err := digestFile(local)
+	if err != nil {
+		return err
+	}
+	t.files[p] = file{digest: d, executable: executable}
+	t.blobs[d.Hash] = &blob{digest: d, path: local}
+	t.mkdir(path.Dir(p))
+	return nil
+}
+
+func (t *tree) mkdir(p string) {
+	for ; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		t.dirs[p] = true
+	}
+}
+
+// addMessage adds a serialized message to the blobs and returns it's digest.
+func (t *tree) addMessage(m proto.Message) (*repb.Digest, error) {
+	d, bytz, err := digestMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	t.blobs[d.Hash] = &blob{digest: d, data: bytz}
+	return d, nil
+}
+
+// root builds the directory messages of the tree and returns the digest of
+// the root directory.
+func (t *tree) root() (*repb.Digest, error) {
+	return t.directory("")
+}
+
+func (t *tree) directory(p string) (*repb.Digest, error) {
+	var dir repb.Directory
+	for fp, f := range t.files {
+		if parent(fp) == p {
+			dir.Files = append(dir.Files, &repb.FileNode{
+				Name:         path.Base(fp),
+				Digest:       f.digest,
+				IsExecutable: f.executable,
+			})
+		}
+	}
+	for dp := range t.dirs {
+		if dp == "" || parent(dp) != p {
+			continue
+		}
+		d, err := t.directory(dp)
+		if err != nil {
+			return nil, err
+		}
+		dir.Directories = append(dir.Directories, &repb.DirectoryNode{
+			Name:   path.Base(dp),
+			Digest: d,
+		})
+	}
+	sort.Sort(filesByName(dir.Files))
+	sort.Sort(dirsByName(dir.Directories))
+	return t.addMessage(&dir)
+}
+
+type filesByName []*repb.FileNode
+
+func (a filesByName) Len() int           { return len(a) }
+func (a filesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a filesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type dirsByName []*repb.DirectoryNode
+
+func (a dirsByName) Len() int           { return len(a) }
+func (a dirsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a dirsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+func parent(p string) string {
+	if d := path.Dir(p); d != "." {
+		return d
+	}
+	return ""
+}
+
+func digestMessage(m proto.Message) (*repb.Digest, []byte, error) {
+	bytz, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &repb.Digest{
+		Hash:      fmt.Sprintf("%x", sha256.Sum256(bytz)),
+		SizeBytes: int64(len(bytz)),
+	}, bytz, nil
+}
+
+func digestFile(p string) (*repb.Digest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &repb.Digest{
+		Hash:      fmt.Sprintf("%x", h.Sum(nil)),
+		SizeBytes: n,
+	}, nil
+}
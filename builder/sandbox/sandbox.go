@@ -0,0 +1,171 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sandbox runs commands so that only the files they declare are
+// visible to them, and reports the files they tried to read without
+// declaring them.
+//
+// On Linux commands are run in new user and mount namespaces, or only a
+// new mount namespace when user namespaces aren't available and build is
+// run as root, where the declared inputs, the directory the command runs in
+// and the tools are bind mounted in to an empty root. When neither can be
+// set up for a command and build is run as root, the tree is bind mounted
+// in the namespace of the host instead and unmounted once the command
+// exits. Commands that can't be sandboxed fail, unless BUILD_SANDBOX_UNCONFINED
+// is set, then they run unconfined with a warning and are only traced.
+// Tracing the files a command reads is supported on amd64.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bldy.build/build/util"
+)
+
+// DefaultTools are the directories of the host that are visible in every
+// sandbox, along with the ones in BUILD_SANDBOX_TOOLS.
+var DefaultTools = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/libx32", "/etc"}
+
+// system paths are visible in sandboxes but aren't mounted from the host
+// like the rest, and reading them isn't reported.
+var system = []string{"/dev", "/proc"}
+
+type mount struct {
+	Path     string
+	Writable bool
+}
+
+// config is what the sandbox is set up with, it is passed to the process
+// that sets up the sandbox.
+type config struct {
+	Root   string
+	Dir    string
+	Cmd    string
+	Args   []string
+	Env    []string
+	Mounts []mount
+	Mode   int
+}
+
+// Executor runs commands in a sandbox.
+type Executor struct {
+	// Inputs are the files and directories commands may read.
+	Inputs []string
+	// Tools are the directories of the programs commands run and the
+	// files they need.
+	Tools []string
+	// Unconfined lets commands that can't be sandboxed run unconfined.
+	Unconfined bool
+}
+
+// New returns an executor that lets commands read inputs and the tools.
+func New(inputs []string) *Executor {
+	tools := append([]string{}, DefaultTools...)
+	tools = append(tools, filepath.SplitList(util.Getenv("BUILD_SANDBOX_TOOLS"))...)
+	unconfined, _ := strconv.ParseBool(util.Getenv("BUILD_SANDBOX_UNCONFINED"))
+	return &Executor{
+		Inputs:     inputs,
+		Tools:      tools,
+		Unconfined: unconfined,
+	}
+}
+
+// Exec runs cmd in dir, which is the only directory it can write to. The
+// paths the command read that weren't declared are reported on stderr.
+func (e *Executor) Exec(ctx context.Context, dir string, stdout, stderr io.Writer, cmd string, env, params []string) error {
+	paths := []string{}
+	paths = append(paths, e.Inputs...)
+	paths = append(paths, e.Tools...)
+	if bin, err := exec.LookPath(cmd); err == nil {
+		if bin, err = filepath.Abs(bin); err == nil {
+			paths = append(paths, filepath.Dir(bin))
+		}
+	}
+	c := &config{
+		Dir:    dir,
+		Cmd:    cmd,
+		Args:   params,
+		Env:    env,
+		Mounts: mounts(dir, paths),
+	}
+	read, err := run(ctx, c, e.Unconfined, stdout, stderr)
+	for _, p := range undeclared(read, c.Mounts) {
+		fmt.Fprintf(stderr, "sandbox: %s read undeclared path %s\n", cmd, p)
+	}
+	return err
+}
+
+// mounts returns the paths that have to be mounted for the directory and
+// paths to be visible, paths in directories that are already visible are
+// left out.
+func mounts(dir string, paths []string) []mount {
+	var ms []mount
+	var clean []string
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			clean = append(clean, filepath.Clean(p))
+		}
+	}
+	sort.Strings(clean)
+	dir = filepath.Clean(dir)
+	for _, p := range clean {
+		if under(p, dir) || under(p, system...) {
+			continue
+		}
+		if len(ms) > 0 && under(p, ms[len(ms)-1].Path) {
+			continue
+		}
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		ms = append(ms, mount{Path: p})
+	}
+	return append(ms, mount{Path: dir, Writable: true})
+}
+
+// undeclared returns the paths that exist on the host but aren't in any of
+// the mounts. Paths of the directories mounts are in aren't reported, since
+// they exist in the sandbox too.
+func undeclared(read []string, ms []mount) []string {
+	var report []string
+	for _, p := range read {
+		p = filepath.Clean(p)
+		if under(p, system...) {
+			continue
+		}
+		declared := false
+		for _, m := range ms {
+			if under(p, m.Path) || under(m.Path, p) {
+				declared = true
+				break
+			}
+		}
+		if declared {
+			continue
+		}
+		if _, err := os.Lstat(p); err == nil {
+			report = append(report, p)
+		}
+	}
+	sort.Strings(report)
+	return report
+}
+
+// under returns true if p is one of the dirs or in one of them.
+func under(p string, dirs ...string) bool {
+	for _, d := range dirs {
+		if p == d || d == "/" || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
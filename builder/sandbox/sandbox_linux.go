@@ -0,0 +1,431 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// initArg is the name the build binary is run with to set up a sandbox.
+const initArg = "build-sandbox-init"
+
+const (
+	userNamespace = iota
+	mountNamespace
+	bindTree
+	unconfined
+)
+
+var modeNames = []string{"user namespace", "mount namespace", "bind mount tree", "unconfined"}
+
+var (
+	mu sync.Mutex
+	// unavailable are the sandboxes this host can't create, whether the
+	// others can be set up is decided for every command.
+	unavailable [unconfined]bool
+)
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == initArg {
+		os.Exit(sandbox())
+	}
+}
+
+// setupError is returned when a sandbox couldn't be set up for a command.
+type setupError struct {
+	mode int
+	msg  string
+	// unsupported is set when the host can't create the sandbox for any
+	// command.
+	unsupported bool
+}
+
+func (e *setupError) Error() string {
+	return fmt.Sprintf("%s: %s", modeNames[e.mode], e.msg)
+}
+
+// run starts the build binary again in the strictest sandbox that can be
+// set up for the command, and returns the paths the command read. Commands
+// that can't be confined fail, unless they are allowed to run unconfined.
+func run(ctx context.Context, c *config, allowUnconfined bool, stdout, stderr io.Writer) ([]string, error) {
+	root, err := ioutil.TempDir("", "sandbox")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// a tree that is still mounted has the directory of the
+		// command in it.
+		if mounted(root) {
+			log.Printf("sandbox: %s is still mounted, it isn't removed", root)
+			return
+		}
+		os.RemoveAll(root)
+	}()
+	c.Root = root
+
+	var failed []string
+	for m := userNamespace; m < unconfined; m++ {
+		mu.Lock()
+		skip := unavailable[m]
+		mu.Unlock()
+		if skip {
+			continue
+		}
+		read, err := start(ctx, c, m, stdout, stderr)
+		serr, ok := err.(*setupError)
+		if !ok {
+			return read, err
+		}
+		if serr.unsupported {
+			mu.Lock()
+			unavailable[m] = true
+			mu.Unlock()
+		}
+		// a weaker sandbox may still work for this command.
+		failed = append(failed, serr.Error())
+	}
+	if !allowUnconfined {
+		return nil, fmt.Errorf("sandbox: %s can't be sandboxed, set BUILD_SANDBOX_UNCONFINED to run it unconfined: %s", c.Cmd, strings.Join(failed, ", "))
+	}
+	fmt.Fprintf(stderr, "sandbox: %s isn't confined, it is only traced: %s\n", c.Cmd, strings.Join(failed, ", "))
+	return start(ctx, c, unconfined, stdout, stderr)
+}
+
+// mounted returns true if something is mounted on the directory.
+func mounted(dir string) bool {
+	var st, parent syscall.Stat_t
+	if syscall.Stat(dir, &st) != nil || syscall.Stat(filepath.Dir(dir), &parent) != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
+
+func start(ctx context.Context, c *config, m int, stdout, stderr io.Writer) ([]string, error) {
+	c.Mode = m
+	bytz, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	configR, configW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer configR.Close()
+	defer configW.Close()
+	reportR, reportW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reportR.Close()
+	defer reportW.Close()
+
+	// killing the process that set up the sandbox kills the command, it
+	// is traced or dies along with its parent.
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = []string{initArg}
+	cmd.Env = c.Env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{configR, reportW}
+	switch m {
+	case userNamespace:
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		}
+	case mountNamespace:
+		if os.Geteuid() != 0 {
+			return nil, &setupError{mode: m, msg: "mount namespaces need root", unsupported: true}
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	case bindTree:
+		if os.Geteuid() != 0 {
+			return nil, &setupError{mode: m, msg: "bind mounts need root", unsupported: true}
+		}
+		// the tree is mounted in the namespace of the host, it is
+		// unmounted once the command exits.
+		defer func() {
+			if err := syscall.Unmount(c.Root, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+				log.Printf("sandbox: unmounting %s: %s", c.Root, err.Error())
+			}
+		}()
+	}
+	if err := cmd.Start(); err != nil {
+		// a build that was cancelled says nothing about the host.
+		if m == unconfined || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &setupError{mode: m, msg: err.Error(), unsupported: true}
+	}
+	configR.Close()
+	reportW.Close()
+	go func() {
+		configW.Write(bytz)
+		configW.Close()
+	}()
+
+	var r report
+	bytz, _ = ioutil.ReadAll(reportR)
+	err = cmd.Wait()
+	if len(bytz) > 0 {
+		if jsonErr := json.Unmarshal(bytz, &r); jsonErr != nil && err == nil {
+			err = jsonErr
+		}
+	}
+	if r.Setup != "" {
+		return nil, &setupError{mode: m, msg: r.Setup}
+	}
+	return r.Read, err
+}
+
+// report is what the sandbox sends back after the command exits.
+type report struct {
+	// Setup is set if the sandbox couldn't be set up.
+	Setup string
+	Read  []string
+}
+
+// sandbox runs in the new namespaces, it sets up the sandbox and runs the
+// command and writes the paths it read to the report.
+func sandbox() int {
+	// ptrace requests have to come from the thread that started the
+	// command.
+	runtime.LockOSThread()
+
+	var (
+		c config
+		r report
+	)
+	out := json.NewEncoder(os.NewFile(4, "report"))
+	if err := json.NewDecoder(os.NewFile(3, "config")).Decode(&c); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: reading config: %s\n", err.Error())
+		return 127
+	}
+	if c.Mode != unconfined {
+		if err := isolate(&c); err != nil {
+			r.Setup = err.Error()
+			out.Encode(r)
+			return 127
+		}
+	}
+	if err := os.Chdir(c.Dir); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err.Error())
+		return 127
+	}
+	var code int
+	r.Read, code = trace(c.Cmd, c.Args, c.Env)
+	out.Encode(r)
+	return code
+}
+
+// isolate mounts the paths in the config in to an empty root and changes
+// the root to it.
+func isolate(c *config) error {
+	// the mounts of the host are left alone when the tree is mounted in
+	// its namespace.
+	if c.Mode != bindTree {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("making mounts private: %s", err.Error())
+		}
+	}
+	if err := syscall.Mount("tmpfs", c.Root, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("mounting root: %s", err.Error())
+	}
+	tmp := filepath.Join(c.Root, "tmp")
+	if err := os.Mkdir(tmp, 01777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", 0, "mode=1777"); err != nil {
+		return fmt.Errorf("mounting /tmp: %s", err.Error())
+	}
+	for _, dev := range []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"} {
+		bind(c.Root, dev, true)
+	}
+	// proc can't always be mounted in user namespaces, commands that
+	// need it get an empty directory.
+	bind(c.Root, "/proc", false)
+
+	for _, m := range c.Mounts {
+		if err := bind(c.Root, m.Path, m.Writable); err != nil {
+			return fmt.Errorf("mounting %s: %s", m.Path, err.Error())
+		}
+	}
+	if err := syscall.Chroot(c.Root); err != nil {
+		return err
+	}
+	return os.Chdir("/")
+}
+
+// flags of mounts that can't be removed when remounting in user namespaces.
+const locked = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// bind mounts the path at the same path under root.
+func bind(root, p string, writable bool) error {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+	dst := filepath.Join(root, p)
+	if stat.IsDir() {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(dst); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, nil, 0644); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Mount(p, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if writable {
+		return nil
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return err
+	}
+	// if the mount can't be made read only, the command can write to
+	// it's inputs but still can't see anything else.
+	syscall.Mount("", dst, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|uintptr(st.Flags)&locked, "")
+	return nil
+}
+
+// trace runs the command, tracing the paths it reads if it's supported,
+// and returns them along with the exit code of the command.
+func trace(name string, args, env []string) ([]string, int) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: traceSupported, Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err.Error())
+		return nil, 127
+	}
+	if !traceSupported {
+		cmd.Wait()
+		return nil, exitCode(cmd.ProcessState.Sys().(syscall.WaitStatus))
+	}
+
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	// the command stops before executing it's first instruction.
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err.Error())
+		return nil, 127
+	}
+	options := syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK |
+		syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEEXEC | 0x100000 // PTRACE_O_EXITKILL
+	if err := syscall.PtraceSetOptions(pid, options); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err.Error())
+		return nil, 127
+	}
+	syscall.PtraceSyscall(pid, 0)
+
+	var (
+		seen      = make(map[string]bool)
+		tracees   = map[int]bool{pid: true}
+		fresh     = make(map[int]bool)
+		inSyscall = make(map[int]bool)
+		code      = 0
+	)
+	for len(tracees) > 0 {
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			break
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(tracees, wpid)
+			delete(inSyscall, wpid)
+			if wpid == pid {
+				code = exitCode(ws)
+			}
+			continue
+		}
+		if !ws.Stopped() {
+			continue
+		}
+		if !tracees[wpid] {
+			tracees[wpid] = true
+			fresh[wpid] = true
+		}
+
+		sig := ws.StopSignal()
+		inject := 0
+		switch {
+		case sig == syscall.SIGTRAP|0x80:
+			if !inSyscall[wpid] {
+				if p, ok := syscallPath(wpid); ok && filepath.IsAbs(p) {
+					seen[p] = true
+				}
+			}
+			inSyscall[wpid] = !inSyscall[wpid]
+		case sig == syscall.SIGTRAP && ws.TrapCause() != 0:
+			switch ws.TrapCause() {
+			case syscall.PTRACE_EVENT_FORK, syscall.PTRACE_EVENT_VFORK, syscall.PTRACE_EVENT_CLONE:
+				if child, err := syscall.PtraceGetEventMsg(wpid); err == nil && !tracees[int(child)] {
+					tracees[int(child)] = true
+					fresh[int(child)] = true
+				}
+			}
+		case sig == syscall.SIGSTOP && fresh[wpid]:
+			// new tracees start stopped.
+		default:
+			inject = int(sig)
+		}
+		delete(fresh, wpid)
+		syscall.PtraceSyscall(wpid, inject)
+	}
+
+	var read []string
+	for p := range seen {
+		read = append(read, p)
+	}
+	return read, code
+}
+
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// readString reads a null terminated string from the memory of a tracee.
+func readString(pid int, addr uintptr) (string, bool) {
+	var buf []byte
+	word := make([]byte, 8)
+	for len(buf) < 4096 {
+		n, err := syscall.PtracePeekData(pid, addr+uintptr(len(buf)), word)
+		if err != nil || n == 0 {
+			return "", false
+		}
+		if i := bytes.IndexByte(word[:n], 0); i >= 0 {
+			return string(append(buf, word[:i]...)), true
+		}
+		buf = append(buf, word[:n]...)
+	}
+	return "", false
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestSandboxBindTree sets up the tree in the namespace of the host, like
+// it is where namespaces aren't available.
+func TestSandboxBindTree(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mounting the tree needs root")
+	}
+	defer withUnavailable(userNamespace, mountNamespace)()
+	testSandbox(t, New(nil))
+}
+
+func TestSandboxUnconfined(t *testing.T) {
+	defer withUnavailable(userNamespace, mountNamespace, bindTree)()
+	dir, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := New(nil)
+	e.Unconfined = false
+	var stdout, stderr bytes.Buffer
+	if err := e.Exec(context.Background(), dir, &stdout, &stderr, "true", nil, nil); err == nil {
+		t.Errorf("a command that couldn't be sandboxed was run")
+	}
+	e.Unconfined = true
+	if err := e.Exec(context.Background(), dir, &stdout, &stderr, "true", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "true isn't confined") {
+		t.Errorf("running unconfined wasn't warned about: %q", stderr.String())
+	}
+}
+
+// withUnavailable marks the sandboxes unavailable and returns a func that
+// marks them available again.
+func withUnavailable(modes ...int) func() {
+	mu.Lock()
+	old := unavailable
+	for _, m := range modes {
+		unavailable[m] = true
+	}
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		unavailable = old
+		mu.Unlock()
+	}
+}
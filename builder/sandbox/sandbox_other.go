@@ -0,0 +1,19 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+func run(ctx context.Context, c *config, allowUnconfined bool, stdout, stderr io.Writer) ([]string, error) {
+	return nil, fmt.Errorf("sandboxing isn't supported on %s", runtime.GOOS)
+}
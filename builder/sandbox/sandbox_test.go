@@ -0,0 +1,88 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sandboxing is only supported on linux")
+	}
+	testSandbox(t, New(nil))
+}
+
+func testSandbox(t *testing.T, e *Executor) {
+	project, err := ioutil.TempDir("", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(project)
+	dir, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	declared := filepath.Join(project, "declared.txt")
+	secret := filepath.Join(project, "secret.txt")
+	for _, f := range []string{declared, secret} {
+		if err := ioutil.WriteFile(f, []byte(filepath.Base(f)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.Inputs = append(e.Inputs, declared)
+	var stdout, stderr bytes.Buffer
+	err = e.Exec(context.Background(), dir, &stdout, &stderr, "sh", nil, []string{
+		"-c",
+		"cat " + declared + " > out.txt; cat " + secret + " 2>/dev/null; true",
+	})
+	if err != nil {
+		t.Fatalf("%s: %s", err, stderr.String())
+	}
+	bytz, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytz) != "declared.txt" {
+		t.Errorf("out.txt = %q", bytz)
+	}
+
+	if strings.Contains(stdout.String(), "secret.txt") {
+		t.Errorf("undeclared file was readable in the sandbox")
+	}
+	if traceSupported && !strings.Contains(stderr.String(), "read undeclared path "+secret) {
+		t.Errorf("reading %s wasn't reported: %q", secret, stderr.String())
+	}
+	if strings.Contains(stderr.String(), declared) {
+		t.Errorf("reading a declared input was reported: %q", stderr.String())
+	}
+}
+
+func TestSandboxExitStatus(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sandboxing is only supported on linux")
+	}
+	dir, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var stdout, stderr bytes.Buffer
+	err = New(nil).Exec(context.Background(), dir, &stdout, &stderr, "sh", nil, []string{"-c", "exit 3"})
+	if err == nil || err.Error() != "exit status 3" {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+}
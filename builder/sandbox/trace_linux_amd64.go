@@ -0,0 +1,36 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sandbox
+
+import "syscall"
+
+const traceSupported = true
+
+// system calls that aren't in the syscall package.
+const (
+	sysExecveat   = 322
+	sysStatx      = 332
+	sysOpenat2    = 437
+	sysFaccessat2 = 439
+)
+
+// syscallPath returns the path the system call a tracee is entering reads,
+// if it reads one.
+func syscallPath(pid int) (string, bool) {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		return "", false
+	}
+	var addr uint64
+	switch regs.Orig_rax {
+	case syscall.SYS_OPEN, syscall.SYS_STAT, syscall.SYS_LSTAT, syscall.SYS_ACCESS, syscall.SYS_EXECVE, syscall.SYS_READLINK:
+		addr = regs.Rdi
+	case syscall.SYS_OPENAT, syscall.SYS_NEWFSTATAT, syscall.SYS_READLINKAT, syscall.SYS_FACCESSAT, sysExecveat, sysStatx, sysOpenat2, sysFaccessat2:
+		addr = regs.Rsi
+	default:
+		return "", false
+	}
+	return readString(pid, uintptr(addr))
+}
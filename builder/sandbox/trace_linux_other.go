@@ -0,0 +1,16 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !amd64
+// +build linux,!amd64
+
+package sandbox
+
+// reading the registers of tracees isn't implemented for this
+// architecture, commands are sandboxed but not traced.
+const traceSupported = false
+
+func syscallPath(pid int) (string, bool) {
+	return "", false
+}
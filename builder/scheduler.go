@@ -0,0 +1,166 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"container/heap"
+	"sync"
+
+	"bldy.build/build"
+)
+
+// scheduler hands out nodes once all of their children are done, highest
+// priority first. Every node keeps a count of the children that aren't
+// done yet and is pushed to the ready queue when it drops to zero, so a
+// node shared by many parents is built once and no goroutines are spent
+// waiting on nodes.
+//
+// Nodes are only handed out when their claims fit in the budget, when the
+// node with the highest priority doesn't fit the next one that does is
+// handed out instead.
+type scheduler struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	ready     PriorityQueue
+	remaining int
+	closed    bool
+	cancelled bool
+
+	// budget is the amount of every resource that can be claimed at
+	// once, resources that aren't in the budget aren't limited.
+	budget    build.Resources
+	claimed   build.Resources
+	running   int
+	exclusive bool
+}
+
+// newScheduler returns a scheduler for the nodes reachable from root, with
+// the nodes that don't depend on anything ready.
+func newScheduler(root *Node, budget build.Resources) *scheduler {
+	s := &scheduler{
+		budget:  budget,
+		claimed: make(build.Resources),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	seen := map[*Node]bool{root: true}
+	stack := []*Node{root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n.pending = len(n.Children)
+		n.claim = s.clamp(build.ClaimOf(n.Target, n.Attributes))
+		if n.pending == 0 {
+			s.ready = append(s.ready, n)
+		}
+		for _, c := range n.Children {
+			if !seen[c] {
+				seen[c] = true
+				stack = append(stack, c)
+			}
+		}
+	}
+	heap.Init(&s.ready)
+	s.remaining = len(seen)
+	return s
+}
+
+// clamp lowers claims that are bigger than the budget to the budget, so
+// the targets that make them are built alone instead of never.
+func (s *scheduler) clamp(c build.Claim) build.Claim {
+	for name, n := range c.Resources {
+		if limit := s.budget[name]; limit > 0 && n > limit {
+			c.Resources[name] = limit
+		}
+	}
+	return c
+}
+
+// next blocks until a node is ready and fits in the budget and returns it,
+// it returns nil when every node is done.
+func (s *scheduler) next() *Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.cancelled {
+			return nil
+		}
+		if n := s.take(); n != nil {
+			for name, c := range n.claim.Resources {
+				s.claimed[name] += c
+			}
+			s.running++
+			s.exclusive = n.claim.Exclusive
+			return n
+		}
+		if s.closed {
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+// take pops the ready node with the highest priority that fits.
+func (s *scheduler) take() *Node {
+	if s.exclusive {
+		return nil
+	}
+	var skipped []*Node
+	var n *Node
+	for s.ready.Len() > 0 {
+		c := heap.Pop(&s.ready).(*Node)
+		if s.fits(c.claim) {
+			n = c
+			break
+		}
+		skipped = append(skipped, c)
+	}
+	for _, c := range skipped {
+		heap.Push(&s.ready, c)
+	}
+	return n
+}
+
+func (s *scheduler) fits(c build.Claim) bool {
+	if c.Exclusive {
+		return s.running == 0
+	}
+	for name, n := range c.Resources {
+		if limit := s.budget[name]; limit > 0 && s.claimed[name]+n > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// done marks the node as done, releasing its claims and readying the
+// parents that were only waiting on it.
+func (s *scheduler) done(n *Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, c := range n.claim.Resources {
+		s.claimed[name] -= c
+	}
+	s.running--
+	if n.claim.Exclusive {
+		s.exclusive = false
+	}
+	for _, parent := range n.Parents {
+		if parent.pending--; parent.pending == 0 {
+			heap.Push(&s.ready, parent)
+		}
+	}
+	if s.remaining--; s.remaining == 0 {
+		s.closed = true
+	}
+	s.cond.Broadcast()
+}
+
+// cancel stops handing out nodes, the workers waiting on next get nil.
+func (s *scheduler) cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancelled = true
+	s.cond.Broadcast()
+}
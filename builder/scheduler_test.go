@@ -0,0 +1,201 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"bldy.build/build"
+)
+
+func newTestNode(name string) *Node {
+	return &Node{
+		Type:     name,
+		Parents:  make(map[string]*Node),
+		Children: make(map[string]*Node),
+		Priority: -1,
+	}
+}
+
+func dependOn(parent, child *Node) {
+	parent.Children[child.Type] = child
+	child.Parents[parent.Type] = parent
+}
+
+// schedule runs every node of the graph with the given number of workers
+// calling visit on each node before marking it done.
+func schedule(root *Node, workers int, visit func(*Node)) {
+	scheduleWithBudget(root, nil, workers, visit)
+}
+
+func scheduleWithBudget(root *Node, budget build.Resources, workers int, visit func(*Node)) {
+	s := newScheduler(root, budget)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for n := s.next(); n != nil; n = s.next() {
+				visit(n)
+				s.done(n)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSchedulerDiamond(t *testing.T) {
+	// root depends on a and b, which both depend on c and d, d depends
+	// on c too.
+	root, a, b, c, d := newTestNode("root"), newTestNode("a"), newTestNode("b"), newTestNode("c"), newTestNode("d")
+	dependOn(root, a)
+	dependOn(root, b)
+	dependOn(a, c)
+	dependOn(b, c)
+	dependOn(a, d)
+	dependOn(b, d)
+	dependOn(d, c)
+
+	var mu sync.Mutex
+	done := make(map[*Node]int)
+	schedule(root, 4, func(n *Node) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, child := range n.Children {
+			if done[child] == 0 {
+				t.Errorf("%s was handed out before %s", n.Type, child.Type)
+			}
+		}
+		done[n]++
+	})
+	for _, n := range []*Node{root, a, b, c, d} {
+		if done[n] != 1 {
+			t.Errorf("%s was handed out %d times, expected once", n.Type, done[n])
+		}
+	}
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	root := newTestNode("root")
+	for i := 0; i < 20; i++ {
+		n := newTestNode(fmt.Sprintf("n%d", i))
+		n.Attributes.Resources = build.Resources{"mem_mb": 1024 * (i%3 + 1)}
+		n.Attributes.Exclusive = i == 7
+		dependOn(root, n)
+	}
+	budget := build.Resources{"cpu": 8, "mem_mb": 2048}
+
+	var mu sync.Mutex
+	running := make(map[*Node]bool)
+	scheduleWithBudget(root, budget, 8, func(n *Node) {
+		mu.Lock()
+		running[n] = true
+		claimed := make(build.Resources)
+		exclusive := false
+		for r := range running {
+			for name, c := range r.claim.Resources {
+				claimed[name] += c
+			}
+			exclusive = exclusive || r.claim.Exclusive
+		}
+		if exclusive && len(running) > 1 {
+			t.Errorf("%d targets are building alongside an exclusive one", len(running)-1)
+		}
+		for name, limit := range budget {
+			if claimed[name] > limit {
+				t.Errorf("%d %s is claimed, the budget is %d", claimed[name], name, limit)
+			}
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		delete(running, n)
+		mu.Unlock()
+	})
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	// a chain of nodes, only one of which can be built at a time.
+	root := newTestNode("root")
+	last := root
+	for i := 0; i < 10; i++ {
+		n := newTestNode(fmt.Sprintf("n%d", i))
+		dependOn(last, n)
+		last = n
+	}
+	s := newScheduler(root, nil)
+	var wg sync.WaitGroup
+	wg.Add(4)
+	var mu sync.Mutex
+	visited := 0
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer wg.Done()
+			for n := s.next(); n != nil; n = s.next() {
+				mu.Lock()
+				if visited++; visited == 3 {
+					s.cancel()
+				}
+				mu.Unlock()
+				s.done(n)
+			}
+		}()
+	}
+	// the workers waiting for the chain to be built have to be woken up.
+	wg.Wait()
+	if visited != 3 {
+		t.Errorf("%d nodes were handed out, expected none after the third", visited)
+	}
+}
+
+// syntheticGraph returns the root of a graph of n nodes where every node
+// depends on a few random nodes created before it.
+func syntheticGraph(n int) *Node {
+	r := rand.New(rand.NewSource(1))
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		nodes[i] = newTestNode(fmt.Sprintf("//n:%d", i))
+		for j := 0; i > 0 && j < 4; j++ {
+			dependOn(nodes[i], nodes[r.Intn(i)])
+		}
+	}
+	root := newTestNode("//:root")
+	for _, node := range nodes {
+		if len(node.Parents) == 0 {
+			dependOn(root, node)
+		}
+	}
+	return root
+}
+
+func BenchmarkScheduler(b *testing.B) {
+	root := syntheticGraph(100000)
+	const workers = 8
+	goroutines := runtime.NumGoroutine()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var max int
+		var mu sync.Mutex
+		schedule(root, workers, func(*Node) {
+			g := runtime.NumGoroutine()
+			mu.Lock()
+			if g > max {
+				max = g
+			}
+			mu.Unlock()
+		})
+		if max > goroutines+workers {
+			b.Fatalf("%d goroutines were running, expected at most %d", max, goroutines+workers)
+		}
+	}
+}
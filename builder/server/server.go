@@ -0,0 +1,258 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server runs commands in a long running build server, so the
+// graph of targets and the digests of files are kept in memory between
+// builds instead of being computed by every invocation.
+//
+// The server listens on a unix socket in the project, clients write a
+// request as JSON and the server streams responses back as newline
+// delimited JSON, the last of which has the exit code of the command.
+// Commands are run one at a time.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+// Stop is the command that shuts the server down.
+const Stop = "stop"
+
+// ErrNotRunning is returned when there is no server to send requests to.
+var ErrNotRunning = errors.New("the build server isn't running")
+
+// Request is a command a client forwards to the server.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Flags are the flags the client was run with that weren't left at
+	// their defaults.
+	Flags map[string]string `json:"flags,omitempty"`
+	Env   []string          `json:"env,omitempty"`
+	Wd    string            `json:"wd"`
+	// Binary identifies the binary of the client.
+	Binary string `json:"binary"`
+}
+
+// Response is a message the server streams back while running a command.
+type Response struct {
+	Event  *builder.Event `json:"event,omitempty"`
+	Stdout string         `json:"stdout,omitempty"`
+	Stderr string         `json:"stderr,omitempty"`
+	// Done is set on the last response, with the exit code of the
+	// command.
+	Done bool `json:"done,omitempty"`
+	Code int  `json:"code"`
+	// Restart is set when the server was built from a different binary
+	// than the client, the server exits and the client has to start a
+	// new one.
+	Restart bool `json:"restart,omitempty"`
+}
+
+// Stream writes responses to a client.
+type Stream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func (s *Stream) write(r Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// once the client hangs up the command runs to the end without it.
+	if s.err == nil {
+		s.err = s.enc.Encode(r)
+	}
+	return s.err
+}
+
+// Event sends a build event to the client.
+func (s *Stream) Event(e builder.Event) error {
+	return s.write(Response{Event: &e})
+}
+
+// Printf prints to the stdout of the client.
+func (s *Stream) Printf(format string, v ...interface{}) error {
+	return s.write(Response{Stdout: fmt.Sprintf(format, v...)})
+}
+
+// Errorf prints to the stderr of the client.
+func (s *Stream) Errorf(format string, v ...interface{}) error {
+	return s.write(Response{Stderr: fmt.Sprintf(format, v...)})
+}
+
+// Handler runs a command and returns its exit code.
+type Handler func(r *Request, s *Stream) int
+
+// Server serves requests on a listener.
+type Server struct {
+	Handler Handler
+	// Idle is how long the server waits for requests before it shuts
+	// down, it doesn't if it is zero.
+	Idle time.Duration
+	// Binary identifies the binary of the server, a request from a
+	// different binary shuts the server down.
+	Binary string
+
+	// run serializes commands.
+	run    sync.Mutex
+	conns  sync.WaitGroup
+	mu     sync.Mutex
+	l      net.Listener
+	active int
+	idle   *time.Timer
+	closed bool
+}
+
+// Serve accepts connections until the server is idle for too long or is
+// stopped, it returns nil once the commands that were running are done.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.l = l
+	if s.Idle > 0 {
+		s.idle = time.AfterFunc(s.Idle, s.Close)
+	}
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				s.conns.Wait()
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.active++
+		if s.idle != nil {
+			s.idle.Stop()
+		}
+		s.mu.Unlock()
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			if s.active--; s.active == 0 && s.idle != nil {
+				s.idle.Reset(s.Idle)
+			}
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	var r Request
+	if err := json.NewDecoder(conn).Decode(&r); err != nil {
+		return
+	}
+	st := &Stream{enc: json.NewEncoder(conn)}
+	switch {
+	case r.Command == Stop:
+		s.Close()
+		st.write(Response{Done: true})
+	case s.Binary != "" && r.Binary != s.Binary:
+		s.Close()
+		st.write(Response{Done: true, Restart: true})
+	default:
+		s.run.Lock()
+		code := s.Handler(&r, st)
+		s.run.Unlock()
+		st.write(Response{Done: true, Code: code})
+	}
+}
+
+// Close stops the server from accepting connections, the commands that
+// are running are finished.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	if s.l != nil {
+		s.l.Close()
+	}
+}
+
+// Socket returns the path of the socket of the server of a project.
+func Socket(project string) string {
+	return filepath.Join(project, ".build.sock")
+}
+
+// Log returns the path of the file the server of a project logs to.
+func Log(project string) string {
+	return filepath.Join(project, ".build.log")
+}
+
+// Listen listens on the socket at path, removing it first if it was left
+// behind by a server that isn't running anymore.
+func Listen(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a build server is already listening on %s", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %s", path, err.Error())
+	}
+	return l, nil
+}
+
+// Do sends the request to the server listening on the socket at path and
+// calls f with every response until the last, which it returns.
+func Do(path string, r *Request, f func(Response)) (*Response, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(r); err != nil {
+		return nil, fmt.Errorf("sending request: %s", err.Error())
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err != nil {
+			return nil, fmt.Errorf("the build server hung up: %s", err.Error())
+		}
+		if resp.Done {
+			return &resp, nil
+		}
+		f(resp)
+	}
+}
+
+// Binary identifies the running binary by its path, size and modification
+// time, so a server that was started before the binary was rebuilt is
+// replaced.
+func Binary() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return exe
+	}
+	return fmt.Sprintf("%s %d %d", exe, fi.Size(), fi.ModTime().UnixNano())
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+func start(t *testing.T, s *Server) (path string, served chan error) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "build.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served = make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+		os.RemoveAll(dir)
+	}()
+	return path, served
+}
+
+func TestDo(t *testing.T) {
+	s := &Server{
+		Binary: "build",
+		Handler: func(r *Request, st *Stream) int {
+			st.Event(builder.Event{Type: builder.TargetStarted, Target: r.Args[0]})
+			st.Printf("built %s\n", r.Args[0])
+			return 3
+		},
+	}
+	path, served := start(t, s)
+	defer s.Close()
+
+	var got []Response
+	resp, err := Do(path, &Request{Args: []string{"//foo:bar"}, Binary: "build"}, func(r Response) {
+		got = append(got, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 3 || resp.Restart {
+		t.Errorf("got exit code %d and restart %t, expected 3 and false", resp.Code, resp.Restart)
+	}
+	expected := []Response{
+		{Event: &builder.Event{Type: builder.TargetStarted, Target: "//foo:bar"}},
+		{Stdout: "built //foo:bar\n"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got responses %+v, expected %+v", got, expected)
+	}
+
+	if _, err := Listen(path); err == nil {
+		t.Errorf("listening on the socket of a running server should fail")
+	}
+
+	// a client built from another binary replaces the server.
+	resp, err = Do(path, &Request{Binary: "newer build"}, func(Response) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Restart {
+		t.Errorf("expected the server to restart")
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Do(path, &Request{}, func(Response) {}); err != ErrNotRunning {
+		t.Errorf("got %v after the server stopped, expected %v", err, ErrNotRunning)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	s := &Server{
+		Idle: 50 * time.Millisecond,
+		Handler: func(r *Request, st *Stream) int {
+			time.Sleep(100 * time.Millisecond)
+			return 0
+		},
+	}
+	path, served := start(t, s)
+
+	// the server isn't idle while it runs commands.
+	if _, err := Do(path, &Request{}, func(Response) {}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		s.Close()
+		t.Fatal("the server didn't shut down when it was idle")
+	}
+}
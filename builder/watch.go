@@ -0,0 +1,141 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"bldy.build/build"
+)
+
+// Watched returns the files and directories the graph depends on, the
+// BUILD files and the files they load along with the paths of targets.
+func (b *Builder) Watched() (paths []string) {
+	seen := make(map[string]bool)
+	for _, n := range b.Nodes {
+		for _, p := range append(append([]string{}, n.files...), build.Paths(n.Target)...) {
+			if filepath.IsAbs(p) && !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// Invalidate updates the graph after the files at paths changed, nodes
+// declared in BUILD files that changed are evaluated again along with the
+// nodes that depend on them. It returns false if the graph doesn't depend on
+// any of the paths.
+func (b *Builder) Invalidate(paths []string) bool {
+	watched := b.Watched()
+	affected := false
+	for _, p := range paths {
+		for _, w := range watched {
+			if p == w || strings.HasPrefix(p, w+string(os.PathSeparator)) {
+				affected = true
+			}
+		}
+	}
+	if !affected {
+		return false
+	}
+
+	changed := make(map[string]bool)
+	for _, p := range paths {
+		changed[p] = true
+	}
+	stale := make(map[*Node]bool)
+	var invalidate func(n *Node)
+	invalidate = func(n *Node) {
+		if stale[n] {
+			return
+		}
+		stale[n] = true
+		for _, p := range n.Parents {
+			invalidate(p)
+		}
+	}
+	for _, n := range b.Nodes {
+		for _, f := range n.files {
+			if changed[f] {
+				invalidate(n)
+			}
+		}
+	}
+	if len(stale) > 0 {
+		for url, n := range b.Nodes {
+			if stale[n] {
+				delete(b.Nodes, url)
+			}
+		}
+		b.Root = b.Add(b.Root.Url.String())
+		b.Root.IsRoot = true
+		// nodes that aren't depended on anymore are forgotten.
+		reachable := map[*Node]bool{b.Root: true}
+		stack := []*Node{b.Root}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, c := range n.Children {
+				if !reachable[c] {
+					reachable[c] = true
+					stack = append(stack, c)
+				}
+			}
+		}
+		for url, n := range b.Nodes {
+			if !reachable[n] {
+				delete(b.Nodes, url)
+			}
+		}
+		for _, n := range b.Nodes {
+			for url, p := range n.Parents {
+				if b.Nodes[url] != p {
+					delete(n.Parents, url)
+				}
+			}
+		}
+	}
+	b.Reset()
+	return true
+}
+
+// Reset prepares the builder to build the graph again.
+func (b *Builder) Reset() {
+	b.Done = make(chan *Node)
+	b.Timeout = make(chan bool)
+	b.stop = make(chan struct{})
+	b.exited = make(chan struct{})
+	b.cancel = new(sync.Once)
+	b.ctx, b.kill = context.WithCancel(context.Background())
+	b.timer = nil
+	for _, c := range []*int64{&b.hits, &b.misses, &b.built, &b.cached, &b.failed} {
+		atomic.StoreInt64(c, 0)
+	}
+	b.spansMu.Lock()
+	b.spans = nil
+	b.spansMu.Unlock()
+	for _, n := range b.Nodes {
+		n.Status = Pending
+		n.Cached = false
+		n.Worker = ""
+		n.Priority = -1
+		n.Critical = 0
+		n.critSet = false
+		n.Start, n.End = 0, 0
+		n.Hash = ""
+		n.Output = ""
+		n.hash = nil
+		n.result = nil
+		n.inputs = nil
+	}
+	b.Total = len(b.Nodes)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"sort"
+	"time"
+
+	"bldy.build/build/cache"
+)
+
+// Explanation is why a target would be rebuilt.
+type Explanation struct {
+	Target string
+	// Cached is set if the target wouldn't be rebuilt.
+	Cached bool
+	// Built is when the target was last cached, it is zero if the target
+	// was never built.
+	Built time.Time
+	// Removed are the inputs of the last build that changed, Added are
+	// what they changed to.
+	Removed, Added []string
+	// Uncached are the dependencies that would be rebuilt too, their
+	// outputs are only known once they are rebuilt.
+	Uncached []string
+}
+
+// Why explains why the node would be rebuilt by comparing the inputs of its
+// hash to the ones of the last time it was cached.
+func (b *Builder) Why(n *Node) (*Explanation, error) {
+	e := &Explanation{Target: n.Url.String()}
+	seen := make(map[*Node]bool)
+	var resolve func(x *Node) error
+	resolve = func(x *Node) error {
+		if seen[x] {
+			return nil
+		}
+		seen[x] = true
+		for _, c := range x.Children {
+			if err := resolve(c); err != nil {
+				return err
+			}
+		}
+		m, err := cache.Lookup(cache.Entry(x.Target.GetName(), x.HashNode()))
+		if err == cache.ErrNotFound {
+			if x != n {
+				e.Uncached = append(e.Uncached, x.Url.String())
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		x.result = m
+		return nil
+	}
+	if err := resolve(n); err != nil {
+		return nil, err
+	}
+	sort.Strings(e.Uncached)
+	if n.result != nil {
+		e.Cached = true
+		e.Built = n.result.Created
+		return e, nil
+	}
+
+	last, err := cache.Latest(n.Target.GetName(), e.Target)
+	if err == cache.ErrNotFound {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+	e.Built = last.Created
+	e.Removed, e.Added = diff(last.Inputs, n.inputs)
+	return e, nil
+}
+
+// diff returns the lines that are only in a and the ones that are only in
+// b.
+func diff(a, b []string) (onlyA, onlyB []string) {
+	count := make(map[string]int)
+	for _, l := range a {
+		count[l]++
+	}
+	for _, l := range b {
+		count[l]--
+	}
+	for _, l := range a {
+		if count[l] > 0 {
+			onlyA = append(onlyA, l)
+			count[l]--
+		}
+	}
+	for _, l := range b {
+		if count[l] < 0 {
+			onlyB = append(onlyB, l)
+			count[l]++
+		}
+	}
+	return onlyA, onlyB
+}
@@ -0,0 +1,25 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package builder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := []string{"Name \"a\"", "file a.c 01 1 bytes", "file b.c 02 1 bytes", "Strip false"}
+	cur := []string{"Name \"a\"", "file a.c 01 1 bytes", "file b.c 03 1 bytes", "Strip true"}
+	removed, added := diff(old, cur)
+	if exp := []string{"file b.c 02 1 bytes", "Strip false"}; !reflect.DeepEqual(removed, exp) {
+		t.Errorf("expected %q to be removed got %q", exp, removed)
+	}
+	if exp := []string{"file b.c 03 1 bytes", "Strip true"}; !reflect.DeepEqual(added, exp) {
+		t.Errorf("expected %q to be added got %q", exp, added)
+	}
+	if removed, added := diff(old, old); removed != nil || added != nil {
+		t.Errorf("expected no changes got %q and %q", removed, added)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cache manages the on disk build cache where targets are built and
+// their outputs are kept between builds.
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bldy.build/build/util"
+)
+
+const (
+	// DefaultMaxSize is the size the cache is trimmed to after a build
+	// when BUILD_CACHE_MAX_SIZE isn't set.
+	DefaultMaxSize = 10 << 30
+	// DefaultMaxAge is how long an entry is kept without being accessed
+	// when BUILD_CACHE_MAX_AGE isn't set.
+	DefaultMaxAge = 30 * 24 * time.Hour
+
+	statsFile = ".stats"
+)
+
+// Dir returns the root of the build cache. It can be set with BUILD_CACHE
+// either in the environment or in the .build file, otherwise it defaults to
+// a per user directory so builds of different users don't collide.
+func Dir() string {
+	if d := util.Getenv("BUILD_CACHE"); d != "" {
+		return d
+	}
+	if d, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(d, "build")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("build-%d", os.Getuid()))
+}
+
+// Touch records an access to the entry, entries that haven't been accessed
+// for the longest time are the first ones to be collected.
+func Touch(entry string) error {
+	now := time.Now()
+	return os.Chtimes(entry, now, now)
+}
+
+// Stats holds the cache usage statistics.
+type Stats struct {
+	Hits    int64
+	Misses  int64
+	Entries int   `json:"-"`
+	Size    int64 `json:"-"`
+	LastGC  time.Time
+}
+
+// HitRate returns the percentage of targets served from the cache.
+func (s *Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses) * 100
+}
+
+// ReadStats returns the stats of the cache.
+func ReadStats() (*Stats, error) {
+	s, err := readStats()
+	if err != nil {
+		return nil, err
+	}
+	entries, blobs, err := list()
+	if err != nil {
+		return nil, err
+	}
+	s.Entries = len(entries)
+	for _, size := range blobs {
+		s.Size += size
+	}
+	return s, nil
+}
+
+// RecordStats adds the hits and misses of a build to the cache stats.
+func RecordStats(hits, misses int64) error {
+	s, err := readStats()
+	if err != nil {
+		return err
+	}
+	s.Hits += hits
+	s.Misses += misses
+	return writeStats(s)
+}
+
+func readStats() (*Stats, error) {
+	var s Stats
+	bytz, err := ioutil.ReadFile(filepath.Join(Dir(), statsFile))
+	if os.IsNotExist(err) {
+		return &s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytz, &s); err != nil {
+		return nil, fmt.Errorf("reading cache stats: %s", err.Error())
+	}
+	return &s, nil
+}
+
+func writeStats(s *Stats) error {
+	bytz, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFile(statsFile, bytz)
+}
+
+// writeFile replaces the file with the given name in the cache directory
+// with a new one, so readers never see it half written.
+func writeFile(name string, bytz []byte) error {
+	if err := os.MkdirAll(Dir(), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(Dir(), name)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytz); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(Dir(), name))
+}
+
+type entry struct {
+	path       string
+	lastAccess time.Time
+	outputs    map[string]bool
+}
+
+// list returns the entries in the cache, least recently used first, and
+// the sizes of the blobs in the store.
+func list() ([]entry, map[string]int64, error) {
+	blobs := make(map[string]int64)
+	filepath.Walk(filepath.Join(Dir(), casDir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			blobs[info.Name()] = info.Size()
+		}
+		return nil
+	})
+
+	fs, err := ioutil.ReadDir(filepath.Join(Dir(), acDir))
+	if os.IsNotExist(err) {
+		return nil, blobs, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var entries []entry
+	for _, f := range fs {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		e := entry{
+			path:       filepath.Join(Dir(), acDir, f.Name()),
+			lastAccess: f.ModTime(),
+			outputs:    make(map[string]bool),
+		}
+		var m Manifest
+		if bytz, err := ioutil.ReadFile(e.path); err == nil && json.Unmarshal(bytz, &m) == nil {
+			for _, o := range m.Outputs {
+				e.outputs[o.Digest] = true
+			}
+			if m.Log != "" {
+				e.outputs[m.Log] = true
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.Sort(byAccess(entries))
+	return entries, blobs, nil
+}
+
+type byAccess []entry
+
+func (a byAccess) Len() int           { return len(a) }
+func (a byAccess) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAccess) Less(i, j int) bool { return a[i].lastAccess.Before(a[j].lastAccess) }
+
+// GC removes entries that haven't been accessed within maxAge, and then
+// the least recently used entries until the store is smaller than maxSize.
+// Blobs that are no longer referred to by any entry are removed as well.
+// A zero maxSize or maxAge disables that limit.
+func GC(maxSize int64, maxAge time.Duration) (removed int, freed int64, err error) {
+	if err := cleanScratch(); err != nil {
+		return 0, 0, err
+	}
+	entries, blobs, err := list()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	refs := make(map[string]int)
+	for _, e := range entries {
+		for d := range e.outputs {
+			refs[d]++
+		}
+	}
+	var size int64
+	for d, n := range blobs {
+		if refs[d] > 0 {
+			size += n
+		}
+	}
+
+	// blobs whose last reference was removed by this collection.
+	evicted := make(map[string]bool)
+	for _, e := range entries {
+		expired := maxAge > 0 && time.Since(e.lastAccess) > maxAge
+		full := maxSize > 0 && size > maxSize
+		if !expired && !full {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		for d := range e.outputs {
+			if refs[d]--; refs[d] == 0 {
+				size -= blobs[d]
+				evicted[d] = true
+			}
+		}
+	}
+
+	for d, n := range blobs {
+		if refs[d] > 0 {
+			continue
+		}
+		// blobs of builds that are still running aren't referred to yet.
+		if !evicted[d] {
+			if stat, err := os.Stat(Blob(d)); err != nil || time.Since(stat.ModTime()) < time.Hour {
+				continue
+			}
+		}
+		if err := os.Remove(Blob(d)); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		freed += n
+	}
+
+	s, err := readStats()
+	if err != nil {
+		return removed, freed, err
+	}
+	s.LastGC = time.Now()
+	return removed, freed, writeStats(s)
+}
+
+// AutoGC collects the cache using the limits set by BUILD_CACHE_MAX_SIZE
+// and BUILD_CACHE_MAX_AGE, it is run after every build.
+func AutoGC() error {
+	maxSize, maxAge := int64(DefaultMaxSize), DefaultMaxAge
+	if s := util.Getenv("BUILD_CACHE_MAX_SIZE"); s != "" {
+		var err error
+		if maxSize, err = ParseSize(s); err != nil {
+			return fmt.Errorf("BUILD_CACHE_MAX_SIZE: %s", err.Error())
+		}
+	}
+	if s := util.Getenv("BUILD_CACHE_MAX_AGE"); s != "" {
+		var err error
+		if maxAge, err = ParseAge(s); err != nil {
+			return fmt.Errorf("BUILD_CACHE_MAX_AGE: %s", err.Error())
+		}
+	}
+	_, _, err := GC(maxSize, maxAge)
+	return err
+}
+
+var sizes = []struct {
+	suffix string
+	size   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses sizes like 512M or 20G.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "IB")
+	if len(str) > 1 {
+		str = strings.TrimSuffix(str, "B")
+	}
+	mult := int64(1)
+	for _, sz := range sizes {
+		if strings.HasSuffix(str, sz.suffix) {
+			str = strings.TrimSuffix(str, sz.suffix)
+			mult = sz.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid size", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// ParseAge parses durations like 7d, in addition to everything
+// time.ParseDuration understands.
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a valid age", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a valid age", s)
+	}
+	return d, nil
+}
+
+// FormatSize formats sizes the way ParseSize parses them.
+func FormatSize(n int64) string {
+	for _, sz := range sizes {
+		if n >= sz.size && sz.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(sz.size), sz.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"20G":   20 << 30,
+		"512M":  512 << 20,
+		"512MB": 512 << 20,
+		"1GiB":  1 << 30,
+		"1.5K":  1536,
+		"100":   100,
+	}
+	for s, exp := range tests {
+		n, err := ParseSize(s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if n != exp {
+			t.Errorf("%s: expected %d got %d", s, exp, n)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"7d":  7 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+	for s, exp := range tests {
+		d, err := ParseAge(s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if d != exp {
+			t.Errorf("%s: expected %s got %s", s, exp, d)
+		}
+	}
+}
+
+func testCache(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BUILD_CACHE", dir)
+	return dir
+}
+
+func addEntry(t *testing.T, name string, size int, access time.Time) string {
+	digest, err := PutBytes([]byte(name + strings.Repeat(".", size-len(name))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Entry(name, []byte{0})
+	m := Manifest{
+		Name:    "//:" + name,
+		Success: true,
+		Outputs: map[string]Output{"out": {Digest: digest, Size: int64(size)}},
+	}
+	if err := Commit(e, &m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(e, access, access); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestGC(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := addEntry(t, "old", 10, now.Add(-10*24*time.Hour))
+	lru := addEntry(t, "lru", 100, now.Add(-2*time.Hour))
+	mru := addEntry(t, "mru", 100, now.Add(-1*time.Hour))
+
+	removed, _, err := GC(150, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 entries to be removed, %d were", removed)
+	}
+	for _, e := range []string{old, lru} {
+		if _, err := os.Stat(e); !os.IsNotExist(err) {
+			t.Errorf("%s should've been collected", e)
+		}
+	}
+	if _, err := os.Stat(mru); err != nil {
+		t.Errorf("%s shouldn't have been collected", mru)
+	}
+}
+
+func TestGCFreesEvictedBlobs(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	// the blobs of both were just written, only the blob of the evicted
+	// entry can't be of a running build.
+	old := addEntry(t, "old", 100, now.Add(-10*24*time.Hour))
+	running, err := PutBytes([]byte("running"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, freed, err := GC(0, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != 100 {
+		t.Errorf("expected 1 entry and 100 bytes to be freed, %d entries and %d bytes were", removed, freed)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("%s should've been collected", old)
+	}
+	if _, err := os.Stat(Blob(running)); err != nil {
+		t.Errorf("the blob of a running build was collected: %s", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	addEntry(t, "a", 100, time.Now())
+	if err := RecordStats(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Entries != 1 || s.Size != 100 {
+		t.Errorf("expected 1 entry of 100 bytes got %d entries of %d bytes", s.Entries, s.Size)
+	}
+	if s.HitRate() != 75 {
+		t.Errorf("expected hit rate to be 75%% got %.1f%%", s.HitRate())
+	}
+}
+
+func TestCommitLookup(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	entry := Entry("lib", []byte{0xde, 0xad})
+	if _, err := Lookup(entry); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	scratch, err := Scratch("lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(scratch)
+	if err := ioutil.WriteFile(filepath.Join(scratch, "lib.a"), []byte("!<arch>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{
+		Name:    "//:lib",
+		Success: true,
+	}
+	if err := m.Ingest(scratch, "lib/lib.a", "lib.a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(entry, &m); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := Lookup(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, ok := cached.Outputs["lib/lib.a"]
+	if !ok {
+		t.Fatal("lib/lib.a is missing from the manifest")
+	}
+
+	if err := os.Remove(Blob(o.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lookup(entry); err == nil {
+		t.Error("expected entry with a missing blob to fail validation")
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	a, err := PutBytes([]byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := PutBytes([]byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatalf("expected identical digests got %s and %s", a, b)
+	}
+
+	dst := filepath.Join(dir, "materialized", "same")
+	if err := Materialize(Output{Digest: a, Size: 4}, dst); err != nil {
+		t.Fatal(err)
+	}
+	bytz, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytz) != "same" {
+		t.Errorf("expected materialized file to contain %q got %q", "same", bytz)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+
+	if err := RecordTimings(Timings{"//a:a": 4 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordTimings(Timings{"//a:a": 2 * time.Second, "//b:b": time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	timings, err := ReadTimings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timings["//a:a"] != 3*time.Second {
+		t.Errorf("expected //a:a to be averaged to 3s, got %s", timings["//a:a"])
+	}
+	if timings["//b:b"] != time.Second {
+		t.Errorf("expected //b:b to take 1s, got %s", timings["//b:b"])
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const casDir = "cas"
+
+// Output is a file produced by a target, stored in the content addressable
+// store under its digest.
+type Output struct {
+	Digest     string
+	Size       int64
+	Executable bool
+}
+
+// Blob returns the path of the blob with the given digest.
+func Blob(digest string) string {
+	if len(digest) < 2 {
+		return filepath.Join(Dir(), casDir, digest)
+	}
+	return filepath.Join(Dir(), casDir, digest[:2], digest)
+}
+
+// Put stores the contents of the file at path in the content addressable
+// store. Identical files produced by different targets are stored once.
+func Put(path string) (Output, error) {
+	var o Output
+	stat, err := os.Stat(path)
+	if err != nil {
+		return o, err
+	}
+	o.Size = stat.Size()
+	o.Executable = stat.Mode()&0111 != 0
+	if o.Digest, err = Digest(path); err != nil {
+		return o, err
+	}
+	if _, err := os.Stat(Blob(o.Digest)); err == nil {
+		return o, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return o, err
+	}
+	defer f.Close()
+	return o, putBlob(o.Digest, f, o.Executable)
+}
+
+// PutBytes stores b in the content addressable store and returns it's digest.
+func PutBytes(b []byte) (string, error) {
+	digest := fmt.Sprintf("%x", sha256.Sum256(b))
+	if _, err := os.Stat(Blob(digest)); err == nil {
+		return digest, nil
+	}
+	return digest, putBlob(digest, bytes.NewReader(b), false)
+}
+
+// ReadBlob returns the contents of the blob with the given digest.
+func ReadBlob(digest string) ([]byte, error) {
+	return ioutil.ReadFile(Blob(digest))
+}
+
+// putBlob writes r to a temporary file and renames it in to place, blobs
+// are read only since they are hard linked in to action directories.
+func putBlob(digest string, r io.Reader, executable bool) error {
+	dir := filepath.Dir(Blob(digest))
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, digest)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if d := fmt.Sprintf("%x", h.Sum(nil)); d != digest {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("storing blob: expected digest %s got %s", digest, d)
+	}
+	var mode os.FileMode = 0444
+	if executable {
+		mode = 0555
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), Blob(digest))
+}
+
+// Materialize places the output at path, hard linking the blob when
+// possible and copying it otherwise.
+func Materialize(o Output, path string) error {
+	blob := Blob(o.Digest)
+	stat, err := os.Stat(blob)
+	if err != nil {
+		return fmt.Errorf("materializing %s: %s", path, err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	if (stat.Mode()&0111 != 0) == o.Executable {
+		if err := os.Link(blob, path); err == nil {
+			return nil
+		}
+	}
+	return copyFile(blob, path, o.Executable)
+}
+
+func copyFile(src, dst string, executable bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var mode os.FileMode = 0644
+	if executable {
+		mode = 0755
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bldy.build/build/util"
+)
+
+const digestsFile = ".digests"
+
+// LoadDigests loads the digests of the files hashed in previous builds, so
+// files that didn't change aren't read again.
+func LoadDigests() error {
+	bytz, err := ioutil.ReadFile(filepath.Join(Dir(), digestsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var d util.FileDigests
+	if err := json.Unmarshal(bytz, &d); err != nil {
+		return fmt.Errorf("reading file digests: %s", err.Error())
+	}
+	util.LoadDigests(d)
+	return nil
+}
+
+// RecordDigests stores the digests of the files hashed in this build for
+// the next ones, files that don't exist anymore are forgotten.
+func RecordDigests() error {
+	d, dirty := util.Digests()
+	if !dirty {
+		return nil
+	}
+	for path := range d {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			delete(d, path)
+		}
+	}
+	bytz, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return writeFile(digestsFile, bytz)
+}
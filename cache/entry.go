@@ -0,0 +1,252 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	acDir      = "ac"
+	scratchDir = ".tmp"
+	// scratch directories older than this are left over from
+	// interrupted builds and are removed by GC.
+	scratchMaxAge = 24 * time.Hour
+)
+
+// ErrNotFound is returned by Lookup when there is no entry.
+var ErrNotFound = errors.New("cache entry not found")
+
+// Manifest is the result of building a target. It is written last, when
+// the outputs of the target are in the content addressable store, so an
+// interrupted build never leaves an entry behind.
+type Manifest struct {
+	Name    string
+	Success bool
+	Created time.Time
+	// Log is the digest of the build log.
+	Log string
+	// Outputs maps the install paths of the target to the outputs
+	// they refer to.
+	Outputs map[string]Output
+	// Inputs are what the key of the entry was computed from, a line
+	// each, they are compared to explain why targets are rebuilt.
+	Inputs []string
+}
+
+// Entry returns the path of the cache entry for a target with the given
+// name and hash.
+func Entry(name string, hash []byte) string {
+	return filepath.Join(Dir(), acDir, fmt.Sprintf("%s-%x", name, hash))
+}
+
+// Scratch creates a new directory for building a target in. Targets are
+// built in scratch directories and their outputs are moved in to the
+// store once the build is complete.
+func Scratch(name string) (string, error) {
+	dir := filepath.Join(Dir(), scratchDir)
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return "", err
+	}
+	scratch, err := ioutil.TempDir(dir, name+"-")
+	if err != nil {
+		return "", err
+	}
+	return scratch, os.Chmod(scratch, os.ModeDir|0755)
+}
+
+// Ingest stores the file or the directory at path, relative to dir, as the
+// output installed at dst.
+func (m *Manifest) Ingest(dir, dst, path string) error {
+	if m.Outputs == nil {
+		m.Outputs = make(map[string]Output)
+	}
+	root := filepath.Join(dir, path)
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		o, err := Put(p)
+		if err != nil {
+			return err
+		}
+		m.Outputs[filepath.Join(dst, rel)] = o
+		return nil
+	})
+}
+
+// Commit atomically writes the manifest of the entry.
+func Commit(entry string, m *Manifest) error {
+	m.Created = time.Now()
+	bytz, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entry), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(entry), "."+filepath.Base(entry))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytz); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), entry); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("committing %s: %s", entry, err.Error())
+	}
+	return nil
+}
+
+// Lookup reads the manifest of an entry and validates that all the outputs
+// it lists are in the store. It returns ErrNotFound if there is no entry and
+// a validation error if the entry is corrupt.
+func Lookup(entry string) (*Manifest, error) {
+	bytz, err := ioutil.ReadFile(entry)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(bytz, &m); err != nil {
+		return nil, fmt.Errorf("reading manifest of %s: %s", entry, err.Error())
+	}
+	for out, o := range m.Outputs {
+		stat, err := os.Stat(Blob(o.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("validating %s: %s is missing", entry, out)
+		}
+		if stat.Size() != o.Size {
+			return nil, fmt.Errorf("validating %s: %s has changed", entry, out)
+		}
+	}
+	return &m, nil
+}
+
+// Digest returns the hex encoded SHA-256 digest of a file.
+func Digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// cleanScratch removes scratch directories left behind by builds that
+// were interrupted.
+func cleanScratch() error {
+	dir := filepath.Join(Dir(), scratchDir)
+	fs, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		if time.Since(f.ModTime()) < scratchMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evict removes every entry of the target with the given name and url,
+// regardless of the hash it was built with.
+func Evict(name, url string) (int, error) {
+	entries, err := filepath.Glob(filepath.Join(Dir(), acDir, name+"-*"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if !isHex(strings.TrimPrefix(filepath.Base(e), name+"-")) {
+			continue
+		}
+		bytz, err := ioutil.ReadFile(e)
+		if err == nil {
+			var m Manifest
+			if err := json.Unmarshal(bytz, &m); err == nil && m.Name != url {
+				continue
+			}
+		}
+		if err := os.Remove(e); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Latest returns the manifest of the entry of the target with the given
+// name and url that was committed last, regardless of the hash it was built
+// with.
+func Latest(name, url string) (*Manifest, error) {
+	entries, err := filepath.Glob(filepath.Join(Dir(), acDir, name+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	var latest *Manifest
+	for _, e := range entries {
+		if !isHex(strings.TrimPrefix(filepath.Base(e), name+"-")) {
+			continue
+		}
+		bytz, err := ioutil.ReadFile(e)
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		if err := json.Unmarshal(bytz, &m); err != nil || m.Name != url {
+			continue
+		}
+		if latest == nil || m.Created.After(latest.Created) {
+			latest = &m
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return latest, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,199 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Remote is a client for HTTP caches that speak the protocol used by
+// bazel-remote, where action results are stored under /ac/ and blobs under
+// /cas/, both keyed by SHA-256 digests. Since action results are build
+// manifests and not protocol buffers, bazel-remote has to be started with
+// --disable_http_ac_validation.
+type Remote struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewRemote returns a client for the remote cache at url.
+func NewRemote(url string) *Remote {
+	return &Remote{
+		URL:    strings.TrimRight(url, "/"),
+		Client: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// ActionKey returns the key the result of a target with the given name and
+// hash is stored under in remote caches.
+func ActionKey(name string, hash []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s-%x", name, hash))))
+}
+
+// Fetch downloads the result stored under key and all the blobs it refers
+// to in to the local store. It returns ErrNotFound if the remote cache
+// doesn't have the result.
+func (r *Remote) Fetch(key string) (*Manifest, error) {
+	bytz, err := r.get("ac", key)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(bytz, &m); err != nil {
+		return nil, fmt.Errorf("reading remote result %s: %s", key, err.Error())
+	}
+	if err := m.check(); err != nil {
+		return nil, fmt.Errorf("reading remote result %s: %s", key, err.Error())
+	}
+
+	fetch := func(digest string, executable bool) error {
+		if _, err := os.Stat(Blob(digest)); err == nil {
+			return nil
+		}
+		bytz, err := r.get("cas", digest)
+		if err != nil {
+			return err
+		}
+		return putBlob(digest, bytes.NewReader(bytz), executable)
+	}
+	if m.Log != "" {
+		if err := fetch(m.Log, false); err != nil {
+			return nil, err
+		}
+	}
+	for out, o := range m.Outputs {
+		if err := fetch(o.Digest, o.Executable); err != nil {
+			return nil, err
+		}
+		// the blob matches its digest, but Lookup would still
+		// discard the result if it doesn't match the size.
+		stat, err := os.Stat(Blob(o.Digest))
+		if err != nil {
+			return nil, err
+		}
+		if stat.Size() != o.Size {
+			return nil, fmt.Errorf("reading remote result %s: %s is %d bytes, expected %d", key, out, stat.Size(), o.Size)
+		}
+	}
+	return &m, nil
+}
+
+// check returns an error if the manifest has outputs that would be
+// materialized outside of the directory of the target or digests that
+// aren't SHA-256 digests, results from remote caches can't be trusted.
+func (m *Manifest) check() error {
+	digests := []string{}
+	if m.Log != "" {
+		digests = append(digests, m.Log)
+	}
+	for path, o := range m.Outputs {
+		clean := filepath.Clean(path)
+		if filepath.IsAbs(path) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("output %q isn't in the directory of the target", path)
+		}
+		digests = append(digests, o.Digest)
+	}
+	for _, d := range digests {
+		if len(d) != sha256.Size*2 || strings.Trim(d, "0123456789abcdef") != "" {
+			return fmt.Errorf("%q isn't a digest", d)
+		}
+	}
+	return nil
+}
+
+// Upload uploads the blobs the manifest refers to that the remote cache
+// doesn't have yet, and then the manifest itself under key.
+func (r *Remote) Upload(key string, m *Manifest) error {
+	upload := func(digest string) error {
+		if ok, err := r.has("cas", digest); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+		f, err := os.Open(Blob(digest))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return r.put("cas", digest, f)
+	}
+	if m.Log != "" {
+		if err := upload(m.Log); err != nil {
+			return err
+		}
+	}
+	for _, o := range m.Outputs {
+		if err := upload(o.Digest); err != nil {
+			return err
+		}
+	}
+	bytz, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return r.put("ac", key, bytes.NewReader(bytz))
+}
+
+func (r *Remote) url(kind, key string) string {
+	return fmt.Sprintf("%s/%s/%s", r.URL, kind, key)
+}
+
+func (r *Remote) get(kind, key string) ([]byte, error) {
+	resp, err := r.Client.Get(r.url(kind, key))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("remote cache: GET %s: %s", r.url(kind, key), resp.Status)
+	}
+}
+
+func (r *Remote) has(kind, key string) (bool, error) {
+	resp, err := r.Client.Head(r.url(kind, key))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("remote cache: HEAD %s: %s", r.url(kind, key), resp.Status)
+	}
+}
+
+func (r *Remote) put(kind, key string, body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, r.url(kind, key), body)
+	if err != nil {
+		return err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote cache: PUT %s: %s", r.url(kind, key), resp.Status)
+	}
+	return nil
+}
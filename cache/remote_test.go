@@ -0,0 +1,139 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRemote(t *testing.T) {
+	srv := httptest.NewServer(NewServer())
+	defer srv.Close()
+	remote := NewRemote(srv.URL)
+	key := ActionKey("lib", []byte{0xbe, 0xef})
+
+	if _, err := remote.Fetch(key); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+	digest, err := PutBytes([]byte("!<arch>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{
+		Name:    "//:lib",
+		Success: true,
+		Outputs: map[string]Output{"lib/lib.a": {Digest: digest, Size: 7}},
+	}
+	if err := remote.Upload(key, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// fetch in to an empty cache, as another machine would.
+	other := testCache(t)
+	defer os.RemoveAll(other)
+	fetched, err := remote.Fetch(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Outputs["lib/lib.a"].Digest != digest {
+		t.Errorf("expected lib/lib.a to be %s got %s", digest, fetched.Outputs["lib/lib.a"].Digest)
+	}
+	bytz, err := ioutil.ReadFile(Blob(digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytz) != "!<arch>" {
+		t.Errorf("unexpected blob contents %q", bytz)
+	}
+}
+
+func TestRemoteRejectsEscapingOutputs(t *testing.T) {
+	srv := httptest.NewServer(NewServer())
+	defer srv.Close()
+	remote := NewRemote(srv.URL)
+
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+	digest, err := PutBytes([]byte("#!/bin/sh\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range []string{"/etc/profile", "../../bin/sh", "bin/../../sh", "."} {
+		key := ActionKey("evil", []byte{byte(i)})
+		m := Manifest{
+			Name:    "//:evil",
+			Success: true,
+			Outputs: map[string]Output{path: {Digest: digest, Size: 10}},
+		}
+		if err := remote.Upload(key, &m); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := remote.Fetch(key); err == nil {
+			t.Errorf("a result with the output %q was fetched", path)
+		}
+	}
+
+	// digests are paths in the store too, the blobs they refer to can't
+	// be uploaded so the result is put as is.
+	key := ActionKey("evil", []byte("digest"))
+	m := Manifest{Name: "//:evil", Success: true, Log: "../../../etc/passwd"}
+	bytz, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.put("ac", key, bytes.NewReader(bytz)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Fetch(key); err == nil {
+		t.Errorf("a result with the log %q was fetched", m.Log)
+	}
+}
+
+func TestRemoteChecksSizes(t *testing.T) {
+	srv := httptest.NewServer(NewServer())
+	defer srv.Close()
+	remote := NewRemote(srv.URL)
+	key := ActionKey("lib", []byte("size"))
+
+	dir := testCache(t)
+	defer os.RemoveAll(dir)
+	digest, err := PutBytes([]byte("!<arch>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{
+		Name:    "//:lib",
+		Success: true,
+		Outputs: map[string]Output{"lib/lib.a": {Digest: digest, Size: 8}},
+	}
+	if err := remote.Upload(key, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	other := testCache(t)
+	defer os.RemoveAll(other)
+	if _, err := remote.Fetch(key); err == nil {
+		t.Error("a result with the wrong size was fetched")
+	}
+}
+
+func TestServerRejectsCorruptBlobs(t *testing.T) {
+	srv := httptest.NewServer(NewServer())
+	defer srv.Close()
+	remote := NewRemote(srv.URL)
+
+	digest := ActionKey("not", []byte("the contents"))
+	if err := remote.put("cas", digest, nil); err == nil {
+		t.Error("expected a blob with the wrong digest to be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Server is an in memory remote cache that speaks the same protocol as
+// Remote. It is meant for tests and for sharing a cache between builds
+// on a single machine, it doesn't persist anything.
+type Server struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewServer returns an empty Server.
+func NewServer() *Server {
+	return &Server{
+		blobs: make(map[string][]byte),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || (parts[0] != "ac" && parts[0] != "cas") || len(parts[1]) != sha256.Size*2 {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.URL.Path
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.mu.RLock()
+		bytz, ok := s.blobs[key]
+		s.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(bytz)))
+		if r.Method == http.MethodGet {
+			w.Write(bytz)
+		}
+	case http.MethodPut:
+		bytz, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parts[0] == "cas" && fmt.Sprintf("%x", sha256.Sum256(bytz)) != parts[1] {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.blobs[key] = bytz
+		s.mu.Unlock()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
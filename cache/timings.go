@@ -0,0 +1,59 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const timingsFile = ".timings"
+
+// Timings are how long targets took to build in previous builds, keyed by
+// target url.
+type Timings map[string]time.Duration
+
+// ReadTimings returns the timings recorded in previous builds.
+func ReadTimings() (Timings, error) {
+	t := make(Timings)
+	bytz, err := ioutil.ReadFile(filepath.Join(Dir(), timingsFile))
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytz, &t); err != nil {
+		return nil, fmt.Errorf("reading timings: %s", err.Error())
+	}
+	return t, nil
+}
+
+// RecordTimings adds the times targets took to build to the timings. Times
+// are averaged with the ones of previous builds so a single slow build
+// doesn't throw the estimates off.
+func RecordTimings(durations Timings) error {
+	if len(durations) == 0 {
+		return nil
+	}
+	t, err := ReadTimings()
+	if err != nil {
+		return err
+	}
+	for name, d := range durations {
+		if old, ok := t[name]; ok {
+			d = (old + d) / 2
+		}
+		t[name] = d
+	}
+	bytz, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return writeFile(timingsFile, bytz)
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"bldy.build/build/cache"
+)
+
+func cacheCmd(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "gc":
+		cacheGC(args[1:])
+	case "stats":
+		cacheStats()
+	case "serve":
+		cacheServe(args[1:])
+	default:
+		usage()
+	}
+}
+
+func cacheGC(args []string) {
+	fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	maxSize := fs.String("max-size", "", "trim the cache to this size, e.g. 20G")
+	maxAge := fs.String("max-age", "", "remove entries that haven't been used for this long, e.g. 7d")
+	fs.Parse(args)
+
+	// limits that aren't given are disabled, the defaults only apply to
+	// the collections after builds.
+	var (
+		size int64
+		age  time.Duration
+		err  error
+	)
+	if *maxSize != "" {
+		if size, err = cache.ParseSize(*maxSize); err != nil {
+			fatalf("%s\n", err.Error())
+		}
+	}
+	if *maxAge != "" {
+		if age, err = cache.ParseAge(*maxAge); err != nil {
+			fatalf("%s\n", err.Error())
+		}
+	}
+	removed, freed, err := cache.GC(size, age)
+	if err != nil {
+		fatalf("cache gc: %s\n", err.Error())
+	}
+	fmt.Printf("removed %d entries, freed %s\n", removed, cache.FormatSize(freed))
+}
+
+func cacheStats() {
+	s, err := cache.ReadStats()
+	if err != nil {
+		fatalf("cache stats: %s\n", err.Error())
+	}
+	fmt.Printf("location:\t%s\n", cache.Dir())
+	fmt.Printf("entries:\t%d\n", s.Entries)
+	fmt.Printf("size:\t\t%s\n", cache.FormatSize(s.Size))
+	fmt.Printf("hits:\t\t%d\n", s.Hits)
+	fmt.Printf("misses:\t\t%d\n", s.Misses)
+	fmt.Printf("hit rate:\t%.1f%%\n", s.HitRate())
+	if !s.LastGC.IsZero() {
+		fmt.Printf("last gc:\t%s\n", s.LastGC.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func cacheServe(args []string) {
+	fs := flag.NewFlagSet("cache serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:9092", "address to listen on")
+	fs.Parse(args)
+
+	fmt.Printf("serving an in memory remote cache on http://%s\n", *addr)
+	if err := http.ListenAndServe(*addr, cache.NewServer()); err != nil {
+		fatalf("cache serve: %s\n", err.Error())
+	}
+}
+
+func fatalf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, v...)
+	os.Exit(1)
+}
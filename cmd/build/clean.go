@@ -0,0 +1,27 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"bldy.build/build/cache"
+	"bldy.build/build/parser"
+)
+
+// clean evicts the cached builds of the given targets.
+func clean(targets []string) {
+	if len(targets) < 1 {
+		usage()
+	}
+	for _, t := range targets {
+		url := parser.NewTargetURLFromString(t)
+		removed, err := cache.Evict(url.Target, url.String())
+		if err != nil {
+			fatalf("clean %s: %s\n", url.String(), err.Error())
+		}
+		fmt.Printf("removed %d entries of %s\n", removed, url.String())
+	}
+}
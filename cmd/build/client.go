@@ -0,0 +1,149 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/builder/server"
+	"bldy.build/build/util"
+)
+
+// forward builds the target in the build server of the project, starting
+// the server if it isn't running. It returns false if the target has to be
+// built in this process instead.
+func forward(t string) bool {
+	project := util.GetProjectPath()
+	// commands run by make share its jobserver, which the build server
+	// can't.
+	if !*useServer || project == "" || strings.Contains(os.Getenv("MAKEFLAGS"), "jobserver") {
+		return false
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	r := &server.Request{
+		Command: "build",
+		Args:    []string{t},
+		Flags:   make(map[string]string),
+		Env:     os.Environ(),
+		Wd:      wd,
+		Binary:  server.Binary(),
+	}
+	flag.Visit(func(f *flag.Flag) {
+		// the dashboard is served by the client.
+		if f.Name != "http" {
+			r.Flags[f.Name] = f.Value.String()
+		}
+	})
+
+	socket := server.Socket(project)
+	// the server is started if it isn't running, and started again if it
+	// was built from another binary.
+	for attempt := 0; attempt < 3; attempt++ {
+		resp, err := request(socket, r)
+		switch {
+		case err == server.ErrNotRunning:
+			if err := startServer(project, socket); err != nil {
+				fmt.Fprintf(os.Stderr, "%s, building without it\n", err)
+				return false
+			}
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s, building without it\n", err)
+			return false
+		case !resp.Restart:
+			os.Exit(resp.Code)
+		}
+	}
+	return false
+}
+
+// request sends the request to the build server and shows the progress of
+// the build.
+func request(socket string, r *server.Request) (*server.Response, error) {
+	outputs := make(map[string]string)
+	ui := newProgress(os.Stderr, *verbose, func(target string) string {
+		return outputs[target]
+	})
+	responses := make(chan server.Response)
+	type result struct {
+		resp *server.Response
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		resp, err := server.Do(socket, r, func(resp server.Response) {
+			responses <- resp
+		})
+		results <- result{resp, err}
+	}()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case resp := <-responses:
+			switch {
+			case resp.Event != nil:
+				if resp.Event.Type == builder.ActionOutput {
+					outputs[resp.Event.Target] += resp.Event.Output
+				}
+				if dash != nil {
+					dash.Handle(*resp.Event)
+				}
+				ui.handle(*resp.Event)
+			case resp.Stdout != "":
+				ui.finish()
+				fmt.Print(resp.Stdout)
+			case resp.Stderr != "":
+				ui.printf("%s", resp.Stderr)
+			}
+		case <-tick.C:
+			ui.draw()
+		case res := <-results:
+			ui.finish()
+			return res.resp, res.err
+		}
+	}
+}
+
+// startServer starts the build server of the project in the background and
+// waits for it to listen on the socket.
+func startServer(project, socket string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("starting the build server: %s", err.Error())
+	}
+	logPath := server.Log(project)
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("starting the build server: %s", err.Error())
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, "-server_idle", serverIdle.String(), "server")
+	cmd.Dir = project
+	cmd.Stdout = log
+	cmd.Stderr = log
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting the build server: %s", err.Error())
+	}
+	cmd.Process.Release()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return fmt.Errorf("the build server didn't start, see %s", logPath)
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"bldy.build/build/builder/dashboard"
+)
+
+// dash is the dashboard builds send their events to, it is nil unless the
+// dashboard is served.
+var dash *dashboard.Dashboard
+
+// serveDashboard serves the dashboard on the address of the -http flag.
+func serveDashboard() {
+	if *httpAddr == "" {
+		return
+	}
+	l, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		fatalf("serving the dashboard: %s\n", err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	dash = dashboard.New()
+	fmt.Fprintf(os.Stderr, "serving the dashboard on http://%s\n", net.JoinHostPort(host, port))
+	go func() {
+		if err := http.Serve(l, dash); err != nil {
+			fmt.Fprintf(os.Stderr, "serving the dashboard: %s\n", err)
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/builder/install"
+	"bldy.build/build/parser"
+)
+
+// installCmd builds the targets and installs their outputs in to a prefix.
+func installCmd(args []string) {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	prefix := fs.String("prefix", "/usr/local", "directory to install in to")
+	method := fs.String("method", "copy", "install files with copy, hardlink or symlink, links point in to the cache")
+	strip := fs.Bool("strip", false, "strip the symbols from executables")
+	force := fs.Bool("force", false, "overwrite files in the prefix that weren't installed by build")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		usage()
+	}
+	in, err := install.New(*prefix, install.Method(*method), *strip)
+	if err != nil {
+		fatalf("install: %s\n", err)
+	}
+	in.Force = *force
+
+	events := openEvents()
+	if events != nil {
+		defer events.Close()
+	}
+	for _, t := range fs.Args() {
+		c := builder.New()
+		configure(&c)
+		c.Root = c.Add(t)
+		c.Root.IsRoot = true
+		c.Total = len(c.Nodes)
+		if _, ok, _ := run(&c, events, *timeout, localProgress(&c)); !ok {
+			if events != nil {
+				events.Close()
+			}
+			os.Exit(1)
+		}
+
+		n, err := in.Install(c.Root.Url.String(), c.Root.Result().Outputs)
+		if err != nil {
+			fatalf("install: %s\n", err)
+		}
+		// the manifest is written after every target, so it has the
+		// files of the targets that were installed if one fails.
+		if err := in.Commit(); err != nil {
+			fatalf("install: %s\n", err)
+		}
+		fmt.Printf("installed %s in to %s, %d files changed\n", c.Root.Url.String(), *prefix, n)
+	}
+}
+
+// uninstall removes the files installed in to a prefix for the targets, or
+// for every target if none are given.
+func uninstall(args []string) {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	prefix := fs.String("prefix", "/usr/local", "directory to uninstall from")
+	fs.Parse(args)
+
+	var targets []string
+	for _, t := range fs.Args() {
+		targets = append(targets, parser.NewTargetURLFromString(t).String())
+	}
+	removed, err := install.Uninstall(*prefix, targets)
+	if err != nil {
+		fatalf("uninstall: %s\n", err)
+	}
+	fmt.Printf("removed %d files from %s\n", removed, *prefix)
+}
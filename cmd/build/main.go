@@ -0,0 +1,249 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	_ "bldy.build/build/targets/build"
+	_ "bldy.build/build/targets/cc"
+	_ "bldy.build/build/targets/golang"
+	_ "bldy.build/build/targets/harvey"
+	_ "bldy.build/build/targets/yacc"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/builder/jobserver"
+	"bldy.build/build/builder/remote"
+	"bldy.build/build/cache"
+)
+
+var (
+	workers = flag.Int("j", runtime.NumCPU(), "number of targets to build in parallel")
+	timeout = flag.Duration("timeout", 0, "give up on the build after this long")
+	verbose = flag.Bool("v", false, "print the output of every target")
+
+	cacheFailures  = flag.Bool("cache_failures", false, "cache failed builds, also set with BUILD_CACHE_FAILURES")
+	retryFailed    = flag.Bool("retry_failed", false, "rebuild targets whose failures were cached")
+	remoteCache    = flag.String("remote_cache", "", "url of a remote cache, also set with BUILD_REMOTE_CACHE")
+	remoteExecutor = flag.String("remote_executor", "", "address of a remote execution server, also set with BUILD_REMOTE_EXECUTOR")
+	remoteInstance = flag.String("remote_instance", "", "instance name used with the remote execution server, also set with BUILD_REMOTE_INSTANCE")
+	profile        = flag.String("profile", "", "write a profile of the build in the chrome trace event format to a file")
+	buildEventFile = flag.String("build_event_json_file", "", "write build events as newline delimited json to a file, or a unix: or tcp: socket")
+	cpus           = flag.Int("cpus", 0, "number of cpus targets can claim at once, defaults to the number of cpus, also set with BUILD_CPUS")
+	memory         = flag.Int("mem_mb", 0, "megabytes of memory targets can claim at once, also set with BUILD_MEM_MB")
+	makeJobserver  = flag.Bool("jobserver", true, "act as a GNU make jobserver for the commands targets run, the jobserver of make is joined instead when the builder is run by make")
+	sandbox        = flag.Bool("sandbox", false, "run commands in a sandbox where only declared inputs are visible, also set with BUILD_SANDBOX")
+	warnUndeclared = flag.Bool("warn_undeclared", false, "warn about files targets leave in their output directories without declaring them, also set with BUILD_WARN_UNDECLARED")
+	useServer      = flag.Bool("server", true, "build in the build server of the project, which keeps the graph in memory between builds, it is started if it isn't running")
+	serverIdle     = flag.Duration("server_idle", 3*time.Hour, "shut the build server down once it is idle for this long")
+	httpAddr       = flag.String("http", "", "serve a dashboard of the build and a JSON API on an address like :8080 while it runs, with watch it stays up between builds")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+	build [flags] target
+	build clean target...
+	build install [--prefix dir] [--method copy|hardlink|symlink] [--strip] [--force] target...
+	build uninstall [--prefix dir] [target...]
+	build watch target
+	build why target
+	build server [stop]
+	build analyze-profile profile
+	build cache gc [--max-size size] [--max-age age]
+	build cache stats
+	build cache serve [--addr address]
+
+flags:
+`)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "cache":
+		cacheCmd(args[1:])
+	case "clean":
+		clean(args[1:])
+	case "install":
+		installCmd(args[1:])
+	case "uninstall":
+		uninstall(args[1:])
+	case "watch":
+		if len(args) != 2 {
+			usage()
+		}
+		serveDashboard()
+		watch(args[1])
+	case "server":
+		serve(args[1:])
+	case "why":
+		if len(args) != 2 {
+			usage()
+		}
+		why(args[1])
+	case "analyze-profile":
+		if len(args) != 2 {
+			usage()
+		}
+		analyzeProfile(args[1])
+	default:
+		if len(args) != 1 {
+			usage()
+		}
+		serveDashboard()
+		if !forward(args[0]) {
+			execute(args[0])
+		}
+	}
+}
+
+func execute(t string) {
+	c := builder.New()
+	configure(&c)
+
+	c.Root = c.Add(t)
+	c.Root.IsRoot = true
+	c.Total = len(c.Nodes)
+
+	events := openEvents()
+	if events != nil {
+		defer events.Close()
+	}
+
+	start := time.Now()
+	done, ok, _ := run(&c, events, *timeout, localProgress(&c))
+	if !ok {
+		if events != nil {
+			events.Close()
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("built %s (%d/%d) in %s\n", c.Root.Url.String(), done, c.Total, time.Since(start))
+}
+
+// configure sets up the builder with the flags.
+func configure(c *builder.Builder) {
+	if c.ProjectPath == "" {
+		fmt.Fprintf(os.Stderr, "You need to be in a git project.\n\n")
+		usage()
+	}
+
+	c.CacheFailures = c.CacheFailures || *cacheFailures
+	c.RetryFailed = *retryFailed
+	c.Sandbox = c.Sandbox || *sandbox
+	c.WarnUndeclared = c.WarnUndeclared || *warnUndeclared
+	if c.Jobserver == nil && *makeJobserver {
+		js, err := jobserver.New(*workers)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		c.Jobserver = js
+	}
+	if *cpus > 0 {
+		c.Budget["cpu"] = *cpus
+	}
+	if *memory > 0 {
+		c.Budget["mem_mb"] = *memory
+	}
+	if *remoteCache != "" {
+		c.Remote = cache.NewRemote(*remoteCache)
+	}
+	if *remoteExecutor != "" {
+		e, err := remote.New(*remoteExecutor, *remoteInstance, c.ProjectPath)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		c.Executor = e
+	}
+}
+
+func openEvents() *builder.EventWriter {
+	if *buildEventFile == "" {
+		return nil
+	}
+	events, err := builder.OpenEventWriter(*buildEventFile)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	return events
+}
+
+// display shows the progress of a build.
+type display interface {
+	handle(e builder.Event)
+	draw()
+	finish()
+	printf(format string, v ...interface{})
+}
+
+// localProgress shows the progress of a build that runs in this process.
+func localProgress(c *builder.Builder) *progress {
+	return newProgress(os.Stderr, *verbose, func(target string) string {
+		return c.Nodes[target].Output
+	})
+}
+
+// run builds the graph of the builder, it returns the number of targets
+// that were done and false if the build failed or timed out.
+func run(c *builder.Builder, events *builder.EventWriter, timeout time.Duration, ui display) (done int, ok, timedOut bool) {
+	handle := func(e builder.Event) {
+		if events != nil {
+			if err := events.Write(e); err != nil {
+				fmt.Fprintf(os.Stderr, "writing build events: %s\n", err)
+				events = nil
+			}
+		}
+		if dash != nil {
+			dash.Handle(e)
+		}
+		ui.handle(e)
+	}
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	go c.Execute(timeout, *workers)
+
+	failed := false
+	for {
+		select {
+		case <-c.Error:
+			// failures are printed when their events are handled.
+			failed = true
+		case e := <-c.Events:
+			handle(e)
+		case <-tick.C:
+			ui.draw()
+		case <-c.Timeout:
+			ui.finish()
+			ui.printf("build timed out after %s\n", timeout)
+			return done, false, true
+		case _, ok := <-c.Done:
+			if ok {
+				done++
+				continue
+			}
+			// handle the events that were sent before the build finished.
+			for len(c.Events) > 0 {
+				handle(<-c.Events)
+			}
+			ui.finish()
+			if *profile != "" {
+				writeProfile(c, *profile)
+			}
+			return done, !failed, false
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+// slowest is the number of targets analyze-profile lists.
+const slowest = 10
+
+func writeProfile(b *builder.Builder, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing profile: %s\n", err)
+		return
+	}
+	defer f.Close()
+	if err := b.WriteProfile(f); err != nil {
+		fmt.Fprintf(os.Stderr, "writing profile: %s\n", err)
+	}
+}
+
+func analyzeProfile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	defer f.Close()
+	a, err := builder.AnalyzeProfile(f)
+	if err != nil {
+		fatalf("reading profile %s: %s\n", path, err)
+	}
+
+	fmt.Printf("critical path (%s):\n", a.CriticalTime.Round(time.Millisecond))
+	for _, t := range a.CriticalPath {
+		printTarget(t)
+	}
+	fmt.Printf("\nslowest targets:\n")
+	for i, t := range a.Targets {
+		if i == slowest {
+			break
+		}
+		printTarget(t)
+	}
+}
+
+func printTarget(t builder.TargetTime) {
+	cached := ""
+	if t.Cached {
+		cached = " (cached)"
+	}
+	fmt.Printf("  %10s  %s%s\n", t.Duration.Round(time.Millisecond), t.Target, cached)
+}
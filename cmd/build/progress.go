@@ -0,0 +1,139 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+type lane struct {
+	target string
+	start  time.Time
+}
+
+// progress renders build events. On terminals it keeps a status area at the
+// bottom of the screen with a lane for every worker and scrolls failure
+// logs above it, otherwise it prints a line for every finished target.
+type progress struct {
+	w       io.Writer
+	tty     bool
+	verbose bool
+	// output returns the build log of a target.
+	output func(target string) string
+
+	total, done, cached, failed int
+	lanes                       map[int]lane
+	start                       time.Time
+	// lines is the height of the status area that was drawn last.
+	lines int
+}
+
+func newProgress(f *os.File, verbose bool, output func(string) string) *progress {
+	return &progress{
+		w:       f,
+		tty:     isTerminal(f),
+		verbose: verbose,
+		output:  output,
+		lanes:   make(map[int]lane),
+		start:   time.Now(),
+	}
+}
+
+func (p *progress) handle(e builder.Event) {
+	switch e.Type {
+	case builder.GraphLoaded:
+		p.total = len(e.Targets)
+	case builder.TargetStarted:
+		p.lanes[e.Worker] = lane{target: e.Target, start: e.Time}
+	case builder.TargetCached:
+		delete(p.lanes, e.Worker)
+		p.done++
+		p.cached++
+		if !p.tty {
+			p.printf("%s cached %s\n", p.count(), e.Target)
+		}
+	case builder.TargetFinished:
+		delete(p.lanes, e.Worker)
+		p.done++
+		if !p.tty {
+			p.printf("%s built %s in %s\n", p.count(), e.Target, round(e.Duration))
+		}
+		if p.verbose {
+			p.printf("%s\n", p.output(e.Target))
+		}
+	case builder.TargetFailed:
+		delete(p.lanes, e.Worker)
+		p.done++
+		p.failed++
+		p.printf("%s FAILED %s\n%s\n", p.count(), e.Target, strings.TrimRight(e.Error, "\n"))
+	}
+	p.draw()
+}
+
+func (p *progress) count() string {
+	return fmt.Sprintf("[%d/%d]", p.done, p.total)
+}
+
+// printf prints above the status area.
+func (p *progress) printf(format string, v ...interface{}) {
+	p.clear()
+	fmt.Fprintf(p.w, format, v...)
+}
+
+// clear erases the status area.
+func (p *progress) clear() {
+	if p.tty && p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// draw redraws the status area, it is called on every event and
+// periodically to update the elapsed times.
+func (p *progress) draw() {
+	if !p.tty {
+		return
+	}
+	p.clear()
+	var buf bytes.Buffer
+	width := terminalWidth(p.w)
+	line := func(format string, v ...interface{}) {
+		s := fmt.Sprintf(format, v...)
+		if len(s) >= width {
+			s = s[:width-1]
+		}
+		buf.WriteString(s)
+		buf.WriteByte('\n')
+		p.lines++
+	}
+	line("%s %d cached, %d failed, %s", p.count(), p.cached, p.failed, round(time.Since(p.start)))
+	var workers []int
+	for w := range p.lanes {
+		workers = append(workers, w)
+	}
+	sort.Ints(workers)
+	for _, w := range workers {
+		l := p.lanes[w]
+		line("  %2d %s %s", w, l.target, round(time.Since(l.start)))
+	}
+	p.w.Write(buf.Bytes())
+}
+
+// finish erases the status area.
+func (p *progress) finish() {
+	p.clear()
+}
+
+func round(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/builder/server"
+	"bldy.build/build/util"
+)
+
+// serve runs the build server of the project, or stops it.
+func serve(args []string) {
+	project := util.GetProjectPath()
+	if project == "" {
+		fmt.Fprintf(os.Stderr, "You need to be in a git project.\n\n")
+		usage()
+	}
+	socket := server.Socket(project)
+	switch {
+	case len(args) == 1 && args[0] == server.Stop:
+		if _, err := server.Do(socket, &server.Request{Command: server.Stop}, func(server.Response) {}); err != nil {
+			fatalf("%s\n", err)
+		}
+		return
+	case len(args) != 0:
+		usage()
+	}
+
+	l, err := server.Listen(socket)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	s := &server.Server{
+		Handler: make(sessions).handle,
+		Idle:    *serverIdle,
+		Binary:  server.Binary(),
+	}
+	if err := s.Serve(l); err != nil {
+		fatalf("%s\n", err)
+	}
+}
+
+// session is a graph the build server keeps between builds.
+type session struct {
+	c *builder.Builder
+	// stamps are the sizes and modification times of the files the graph
+	// depends on when it was last built.
+	stamps map[string]stamp
+}
+
+type stamp struct {
+	size  int64
+	mtime time.Time
+}
+
+func stampOf(path string) stamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{fi.Size(), fi.ModTime()}
+}
+
+// refresh prepares the graph to be built again, the targets declared in
+// BUILD files that changed since the last build are evaluated again.
+func (s *session) refresh() {
+	var changed []string
+	for path, st := range s.stamps {
+		if stampOf(path) != st {
+			changed = append(changed, path)
+		}
+	}
+	s.c.Invalidate(changed)
+	s.c.Reset()
+}
+
+// sessions are the graphs of the builds the server ran, by the directory,
+// target, flags and environment they were run with.
+type sessions map[string]*session
+
+// ignoredEnv are variables shells change all the time, they don't affect
+// builds.
+var ignoredEnv = map[string]bool{"PWD": true, "OLDPWD": true, "SHLVL": true, "_": true}
+
+// handle builds a target for a client, in the directory and with the flags
+// and environment of the client.
+func (ss sessions) handle(r *server.Request, st *server.Stream) int {
+	if r.Command != "build" || len(r.Args) != 1 {
+		st.Errorf("the build server doesn't know how to %s %q\n", r.Command, r.Args)
+		return 1
+	}
+	if err := os.Chdir(r.Wd); err != nil {
+		st.Errorf("%s\n", err)
+		return 1
+	}
+	os.Clearenv()
+	var env []string
+	for _, kv := range r.Env {
+		if i := strings.Index(kv, "="); i > 0 {
+			os.Setenv(kv[:i], kv[i+1:])
+			if !ignoredEnv[kv[:i]] {
+				env = append(env, kv)
+			}
+		}
+	}
+	sort.Strings(env)
+	var flags []string
+	for name, value := range r.Flags {
+		if err := flag.Set(name, value); err != nil {
+			st.Errorf("flag -%s: %s\n", name, err)
+			return 1
+		}
+		flags = append(flags, name+"="+value)
+	}
+	sort.Strings(flags)
+	defer func() {
+		for name := range r.Flags {
+			flag.Set(name, flag.Lookup(name).DefValue)
+		}
+	}()
+
+	// the warnings of the builder are printed by the client.
+	log.SetOutput(forwarder{st})
+	defer log.SetOutput(os.Stderr)
+
+	key := fmt.Sprintf("%s %q %q %q", r.Wd, r.Args, flags, env)
+	s, ok := ss[key]
+	if ok {
+		s.refresh()
+	} else {
+		c := builder.New()
+		configure(&c)
+		c.Root = c.Add(r.Args[0])
+		c.Root.IsRoot = true
+		c.Total = len(c.Nodes)
+		s = &session{c: &c}
+		ss[key] = s
+	}
+
+	events := openEvents()
+	if events != nil {
+		defer events.Close()
+	}
+	start := time.Now()
+	done, ok, timedOut := run(s.c, events, *timeout, forwarder{st})
+	if timedOut {
+		// the commands that are running are killed and the workers
+		// stopped before the next request changes the directory and
+		// environment under them, the graph is left half built so it
+		// isn't used again.
+		s.c.Cancel()
+		delete(ss, key)
+		return 1
+	}
+	s.stamps = make(map[string]stamp)
+	for _, path := range s.c.Watched() {
+		s.stamps[path] = stampOf(path)
+	}
+	if !ok {
+		return 1
+	}
+	st.Printf("built %s (%d/%d) in %s\n", s.c.Root.Url.String(), done, s.c.Total, time.Since(start))
+	return 0
+}
+
+// forwarder shows the progress of a build on a client of the build server.
+type forwarder struct {
+	s *server.Stream
+}
+
+func (f forwarder) handle(e builder.Event) { f.s.Event(e) }
+func (f forwarder) draw()                  {}
+func (f forwarder) finish()                {}
+func (f forwarder) printf(format string, v ...interface{}) {
+	f.s.Errorf(format, v...)
+}
+
+func (f forwarder) Write(p []byte) (int, error) {
+	return len(p), f.s.Errorf("%s", p)
+}
@@ -0,0 +1,22 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// the progress area is only drawn on unix terminals.
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func terminalWidth(w io.Writer) int {
+	return 80
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/builder/notify"
+)
+
+// debounce is how long watch waits for files to stop changing before it
+// rebuilds, editors and git checkouts write many files in a burst.
+const debounce = 200 * time.Millisecond
+
+// watch builds the target and rebuilds it every time the files it depends
+// on change.
+func watch(t string) {
+	c := builder.New()
+	configure(&c)
+
+	c.Root = c.Add(t)
+	c.Root.IsRoot = true
+	c.Total = len(c.Nodes)
+
+	events := openEvents()
+	if events != nil {
+		defer events.Close()
+	}
+	w, err := notify.New()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	defer w.Close()
+
+	for {
+		start := time.Now()
+		if done, ok, _ := run(&c, events, 0, localProgress(&c)); ok {
+			fmt.Printf("built %s (%d/%d) in %s\n", c.Root.Url.String(), done, c.Total, time.Since(start))
+		}
+		watchDirs(w, c.Watched())
+		fmt.Fprintf(os.Stderr, "watching for changes...\n")
+		for !c.Invalidate(changes(w)) {
+		}
+	}
+}
+
+// watchDirs watches the directories of the paths, and every directory in
+// the ones that are paths.
+func watchDirs(w *notify.Watcher, paths []string) {
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			if err := w.Add(filepath.Dir(p)); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			continue
+		}
+		filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if err := w.Add(path); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			return nil
+		})
+	}
+}
+
+// changes blocks until files change and returns their paths once they stop
+// changing.
+func changes(w *notify.Watcher) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	var quiet <-chan time.Time
+	for {
+		select {
+		case p, ok := <-w.Events:
+			if !ok {
+				fatalf("stopped watching files\n")
+			}
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+			quiet = time.After(debounce)
+		case err := <-w.Errors:
+			fatalf("watching files: %s\n", err)
+		case <-quiet:
+			return paths
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"bldy.build/build/builder"
+)
+
+// why explains why a target would be rebuilt.
+func why(t string) {
+	c := builder.New()
+	if c.ProjectPath == "" {
+		fmt.Fprintf(os.Stderr, "You need to be in a git project.\n\n")
+		usage()
+	}
+	n := c.Add(t)
+	e, err := c.Why(n)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	switch {
+	case e.Cached:
+		fmt.Printf("%s is cached, it was built %s\n", e.Target, e.Built.Format(time.RFC1123))
+	case e.Built.IsZero():
+		fmt.Printf("%s was never built\n", e.Target)
+	case len(e.Removed) == 0 && len(e.Added) == 0:
+		fmt.Printf("%s was last built %s, its inputs haven't changed but it isn't cached anymore\n", e.Target, e.Built.Format(time.RFC1123))
+	default:
+		fmt.Printf("%s was last built %s, its inputs changed since:\n", e.Target, e.Built.Format(time.RFC1123))
+		for _, l := range e.Removed {
+			fmt.Printf("  - %s\n", l)
+		}
+		for _, l := range e.Added {
+			fmt.Printf("  + %s\n", l)
+		}
+	}
+	if len(e.Uncached) > 0 {
+		fmt.Printf("\ndependencies that will be rebuilt, the outputs of which may change it:\n")
+		for _, d := range e.Uncached {
+			fmt.Printf("  %s\n", d)
+		}
+	}
+}
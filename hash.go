@@ -0,0 +1,131 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"bldy.build/build/util"
+)
+
+// Hash returns the hash of the attributes of a rule, rules implement
+// Target.Hash with it.
+//
+//	func (s *Sed) Hash() []byte { return build.Hash(s) }
+func Hash(rule interface{}) []byte {
+	h := sha1.New()
+	hashInputs(h, rule)
+	return h.Sum(nil)
+}
+
+// InputHasher is implemented by rules whose hash depends on more than their
+// attributes, like the version of the tools they run. HashInputs writes the
+// inputs to h a line each, usually followed by HashFields.
+type InputHasher interface {
+	HashInputs(h io.Writer)
+}
+
+func hashInputs(h io.Writer, rule interface{}) {
+	if ih, ok := rule.(InputHasher); ok {
+		ih.HashInputs(h)
+		return
+	}
+	HashFields(h, rule)
+}
+
+// Inputs returns the inputs Hash hashes a rule with, a line each, so the
+// reason a hash changed can be found.
+func Inputs(rule interface{}) []string {
+	var buf bytes.Buffer
+	hashInputs(&buf, rule)
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+// HashFields writes the fields of a rule that are tagged with attribute
+// names to h, along with the contents of the files in the fields tagged
+// as paths. Values are written the same way every time, maps are written
+// in the order of their keys.
+func HashFields(h io.Writer, rule interface{}) {
+	v := reflect.Indirect(reflect.ValueOf(rule))
+	fmt.Fprintf(h, "%s\n", v.Type().String())
+	if v.Kind() != reflect.Struct {
+		hashValue(h, v)
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag == "" || field.PkgPath != "" {
+			continue
+		}
+		fmt.Fprintf(h, "%s ", field.Name)
+		hashValue(h, v.Field(i))
+		io.WriteString(h, "\n")
+		if field.Tag.Get("build") == "path" {
+			util.HashFiles(h, pathsOf(v.Field(i)))
+		}
+	}
+}
+
+func hashValue(h io.Writer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		fmt.Fprintf(h, "%q", v.String())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		fmt.Fprintf(h, "%v", v.Interface())
+	case reflect.Slice, reflect.Array:
+		fmt.Fprintf(h, "[%d", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			io.WriteString(h, " ")
+			hashValue(h, v.Index(i))
+		}
+		io.WriteString(h, "]")
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Sort(byString(keys))
+		fmt.Fprintf(h, "{%d", v.Len())
+		for _, k := range keys {
+			io.WriteString(h, " ")
+			hashValue(h, k)
+			io.WriteString(h, ":")
+			hashValue(h, v.MapIndex(k))
+		}
+		io.WriteString(h, "}")
+	case reflect.Struct:
+		io.WriteString(h, "{")
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			fmt.Fprintf(h, " %s:", v.Type().Field(i).Name)
+			hashValue(h, v.Field(i))
+		}
+		io.WriteString(h, "}")
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			io.WriteString(h, "nil")
+			return
+		}
+		hashValue(h, v.Elem())
+	default:
+		fmt.Fprintf(h, "%s", v.Kind())
+	}
+}
+
+// byString sorts the keys of a map by how they are printed.
+type byString []reflect.Value
+
+func (a byString) Len() int      { return len(a) }
+func (a byString) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byString) Less(i, j int) bool {
+	return fmt.Sprint(a[i].Interface()) < fmt.Sprint(a[j].Interface())
+}
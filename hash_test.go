@@ -0,0 +1,105 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/internal"
+	_ "bldy.build/build/targets/build"
+	_ "bldy.build/build/targets/cc"
+	_ "bldy.build/build/targets/golang"
+	_ "bldy.build/build/targets/harvey"
+	_ "bldy.build/build/targets/yacc"
+)
+
+// attribute returns the nth value of a test attribute of type t, paths
+// are the paths of files in dir.
+func attribute(t reflect.Type, dir string, path bool, n int) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		if path {
+			v.SetString(filepath.Join(dir, fmt.Sprintf("file%d", n)))
+		} else {
+			v.SetString(fmt.Sprintf("value%d", n))
+		}
+	case reflect.Bool:
+		v.SetBool(n%2 == 1)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint64, reflect.Uint32:
+		v.SetUint(uint64(n))
+	case reflect.Slice:
+		for i := 0; i <= n; i++ {
+			v = reflect.Append(v, attribute(t.Elem(), dir, path, i))
+		}
+	case reflect.Map:
+		v = reflect.MakeMap(t)
+		v.SetMapIndex(attribute(t.Key(), dir, false, 0), attribute(t.Elem(), dir, path, n))
+	}
+	return v
+}
+
+func TestHashChangesWithAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 2; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range internal.Names() {
+		typ := internal.Get(name)
+		rule := reflect.New(typ)
+		var fields []int
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Tag.Get(name) == "" {
+				continue
+			}
+			fields = append(fields, i)
+			rule.Elem().Field(i).Set(attribute(f.Type, dir, f.Tag.Get("build") == "path", 0))
+		}
+		target := rule.Interface().(build.Target)
+		hash := target.Hash()
+		if !bytes.Equal(hash, target.Hash()) {
+			t.Errorf("%s: hashes of the same target differ", name)
+		}
+
+		for _, i := range fields {
+			f := typ.Field(i)
+			changed := reflect.New(typ)
+			changed.Elem().Set(rule.Elem())
+			changed.Elem().Field(i).Set(attribute(f.Type, dir, f.Tag.Get("build") == "path", 1))
+			if bytes.Equal(hash, changed.Interface().(build.Target).Hash()) {
+				t.Errorf("%s: hash didn't change when %s changed", name, f.Tag.Get(name))
+			}
+		}
+	}
+
+	// changing the contents of a file changes the hash of the targets
+	// that have it as a path.
+	sed := reflect.New(internal.Get("sed")).Interface().(build.Target)
+	reflect.ValueOf(sed).Elem().FieldByName("File").SetString(filepath.Join(dir, "file0"))
+	hash := sed.Hash()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file0"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(hash, sed.Hash()) {
+		t.Errorf("sed: hash didn't change when the contents of its file changed")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 
 	"reflect"
+	"sort"
 )
 
 var (
@@ -42,6 +43,15 @@ func Get(name string) reflect.Type {
 	}
 }
 
+// Names returns the names of the registered types, sorted.
+func Names() (names []string) {
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // GetFieldByTag returns field by tag
 func GetFieldByTag(tn, tag string, p reflect.Type) (*reflect.StructField, error) {
 	if p == nil {
@@ -0,0 +1,42 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import "reflect"
+
+// Paths returns the values of the fields of a target that are tagged as
+// paths, these are the files and directories a target reads from the
+// project.
+func Paths(t Target) (paths []string) {
+	v := reflect.Indirect(reflect.ValueOf(t))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("build") != "path" {
+			continue
+		}
+		paths = append(paths, pathsOf(v.Field(i))...)
+	}
+	return paths
+}
+
+// pathsOf returns the paths in a string or a slice of strings.
+func pathsOf(f reflect.Value) (paths []string) {
+	switch f.Kind() {
+	case reflect.String:
+		if s := f.String(); s != "" {
+			paths = append(paths, s)
+		}
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for j := 0; j < f.Len(); j++ {
+			paths = append(paths, f.Index(j).String())
+		}
+	}
+	return paths
+}
@@ -18,6 +18,7 @@ import (
 	"regexp"
 
 	"strings"
+	"sync"
 
 	"bldy.build/build"
 	"bldy.build/build/ast"
@@ -28,11 +29,17 @@ import (
 )
 
 type Processor struct {
-	vars    map[string]interface{}
-	wd      string
-	seen    map[string]*ast.Func
+	vars map[string]interface{}
+	wd   string
+	seen map[string]*ast.Func
+	// attrs are written while the targets before them are being
+	// received.
+	attrsMu sync.Mutex
+	attrs   map[string]build.Attributes
 	parser  *parser.Parser
 	Targets chan build.Target
+	// files are the files the processor read.
+	files []string
 }
 
 func NewProcessor(p *parser.Parser) *Processor {
@@ -41,8 +48,23 @@ func NewProcessor(p *parser.Parser) *Processor {
 		parser:  p,
 		Targets: make(chan build.Target),
 		seen:    make(map[string]*ast.Func),
+		attrs:   make(map[string]build.Attributes),
 	}
 }
+
+// Files returns the paths of the BUILD file and the files it loaded, it
+// should only be called after every target is received from Targets.
+func (p *Processor) Files() []string {
+	return p.files
+}
+
+// Attributes returns the common attributes of a target, it should only be
+// called after the target is received from Targets.
+func (p *Processor) Attributes(name string) build.Attributes {
+	p.attrsMu.Lock()
+	defer p.attrsMu.Unlock()
+	return p.attrs[name]
+}
 func NewProcessorFromURL(url parser.TargetURL, wd string) (*Processor, error) {
 
 	BUILDPATH := filepath.Join(url.BuildDir(wd, util.GetProjectPath()), "BUILD")
@@ -69,9 +91,9 @@ func NewProcessorFromFile(n string) (*Processor, error) {
 	}
 	ts, _ := filepath.Abs(ks.Name())
 	dir := strings.Split(ts, "/")
-	p := parser.New(n, "/"+filepath.Join(dir[:len(dir)-1]...), ks)
-
-	return NewProcessor(p), nil
+	p := NewProcessor(parser.New(n, "/"+filepath.Join(dir[:len(dir)-1]...), ks))
+	p.files = []string{ts}
+	return p, nil
 }
 
 func (p *Processor) Run() {
@@ -215,6 +237,7 @@ func (p *Processor) runFunc(f *ast.Func) {
 
 		for d := <-loadingProcessor.Targets; d != nil; d = <-loadingProcessor.Targets {
 		}
+		p.files = append(p.files, loadingProcessor.Files()...)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -271,12 +294,9 @@ func (p *Processor) makeTarget(f *ast.Func) (build.Target, error) {
 
 	payload := make(map[string]interface{})
 
-	for key, fn := range f.Params {
+	var attrs build.Attributes
 
-		field, err := internal.GetFieldByTag(f.Name, key, ttype)
-		if err != nil {
-			return nil, err
-		}
+	for key, fn := range f.Params {
 
 		var i interface{}
 		switch fn.(type) {
@@ -289,6 +309,18 @@ func (p *Processor) makeTarget(f *ast.Func) (build.Target, error) {
 			i = fn
 		}
 
+		if ok, err := attrs.Set(key, i); ok {
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", f.File, f.Start.Line, err.Error())
+			}
+			continue
+		}
+
+		field, err := internal.GetFieldByTag(f.Name, key, ttype)
+		if err != nil {
+			return nil, err
+		}
+
 		if field.Type != reflect.TypeOf(i) {
 			// return nil, fmt.Errorf("%s is of type %s not %s.", key, reflect.TypeOf(i).String(), field.Type.String())
 		}
@@ -324,6 +356,9 @@ func (p *Processor) makeTarget(f *ast.Func) (build.Target, error) {
 	default:
 		log.Fatalf("type %s doesn't implement the build.Target interface, check sevki.co/2LLRfc for more information", ttype.String())
 	}
+	p.attrsMu.Lock()
+	p.attrs[t.(build.Target).GetName()] = attrs
+	p.attrsMu.Unlock()
 	return t.(build.Target), nil
 }
 
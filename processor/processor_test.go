@@ -359,3 +359,48 @@ func TestLoop(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRetries(t *testing.T) {
+	p, err := NewProcessorFromFile("tests/retries.BUILD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Run()
+	targ := <-p.Targets
+	if targ.GetName() != "libflaky" {
+		t.Fatalf("expected libflaky got %s", targ.GetName())
+	}
+	if attrs := p.Attributes(targ.GetName()); attrs.Retries != 3 {
+		t.Errorf("expected 3 retries got %d", attrs.Retries)
+	}
+}
+
+func TestResources(t *testing.T) {
+	p, err := NewProcessorFromFile("tests/resources.BUILD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Run()
+	targ := <-p.Targets
+	if targ.GetName() != "kernel" {
+		t.Fatalf("expected kernel got %s", targ.GetName())
+	}
+	attrs := p.Attributes(targ.GetName())
+	if attrs.Resources["cpu"] != 4 || attrs.Resources["mem_mb"] != 2048 {
+		t.Errorf("expected cpu=4,mem_mb=2048 got %s", attrs.Resources)
+	}
+	if !attrs.Exclusive {
+		t.Errorf("expected kernel to be exclusive")
+	}
+
+	// attributes are read while the processor makes the next target.
+	targ = <-p.Targets
+	if targ.GetName() != "init" {
+		t.Fatalf("expected init got %s", targ.GetName())
+	}
+	if attrs := p.Attributes(targ.GetName()); attrs.Resources["cpu"] != 2 || attrs.Exclusive {
+		t.Errorf("expected cpu=2 and init not to be exclusive got %s", attrs.Resources)
+	}
+	for targ := <-p.Targets; targ != nil; targ = <-p.Targets {
+	}
+}
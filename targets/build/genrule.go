@@ -1,15 +1,10 @@
 package build
 
 import (
-	"crypto/sha1"
-	"io"
-
 	"strings"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 )
-import "os"
 
 type GenRule struct {
 	Name         string   `gen_rule:"name"`
@@ -18,12 +13,7 @@ type GenRule struct {
 }
 
 func (g *GenRule) Hash() []byte {
-	h := sha1.New()
-
-	io.WriteString(h, g.Name)
-	util.HashStrings(h, g.Commands)
-	util.HashStrings(h, os.Environ())
-	return []byte{}
+	return build.Hash(g)
 }
 
 func (g *GenRule) Build(c *build.Context) error {
@@ -12,8 +12,7 @@ type Group struct {
 }
 
 func (g *Group) Hash() []byte {
-
-	return []byte(g.Name)
+	return build.Hash(g)
 }
 
 func (g *Group) Build(c *build.Context) error {
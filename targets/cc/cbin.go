@@ -1,7 +1,6 @@
 package cc
 
 import (
-	"crypto/sha1"
 	"fmt"
 
 	"io"
@@ -10,7 +9,6 @@ import (
 	"path/filepath"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 	"sevki.org/lib/prettyprint"
 )
 
@@ -37,40 +35,37 @@ func split(s string, c string) string {
 	return s[i+1:]
 }
 func (cb *CBin) Hash() []byte {
+	return build.Hash(cb)
+}
 
-	h := sha1.New()
-	io.WriteString(h, CCVersion)
-	io.WriteString(h, cb.Name)
-	util.HashFilesWithExt(h, []string(cb.Includes), ".h")
-	util.HashFilesWithExt(h, cb.Sources, ".c")
-	util.HashStrings(h, cb.CompilerOptions)
-	util.HashStrings(h, cb.LinkerOptions)
-	return h.Sum(nil)
+// HashInputs hashes the version of the compiler along with the attributes.
+func (cb *CBin) HashInputs(h io.Writer) {
+	fmt.Fprintf(h, "CCVersion %q\n", CCVersion())
+	fmt.Fprintf(h, "Tools %q\n", Tools())
+	build.HashFields(h, cb)
 }
 
 func (cb *CBin) Build(c *build.Context) error {
 	c.Println(prettyprint.AsJSON(cb))
-	params := []string{"-c"}
-	params = append(params, cb.CompilerOptions...)
-	params = append(params, cb.Sources...)
-
-	params = append(params, cb.Includes.Includes()...)
+	return build.RunActions(c, cb.Actions())
+}
 
-	if err := c.Exec(Compiler(), CCENV, params); err != nil {
-		return fmt.Errorf(err.Error())
-	}
+func (cb *CBin) Actions() []*build.Action {
+	actions := compile(cb.Sources, cb.Includes, cb.Headers, cb.CompilerOptions)
 
 	ldparams := []string{"-o", cb.Name}
 	ldparams = append(ldparams, cb.LinkerOptions...)
+	// libraries of dependencies are installed in to lib or next to the
+	// objects.
+	inputs := []string{"lib", "*.a"}
 	if cb.LinkerFile != "" {
 		ldparams = append(ldparams, cb.LinkerFile)
+		inputs = append(inputs, cb.LinkerFile)
 	}
 
-	// This is done under the assumption that each src file put in this thing
-	// here will comeout as a .o file
 	for _, f := range cb.Sources {
-		_, fname := filepath.Split(f)
-		ldparams = append(ldparams, fmt.Sprintf("%s.o", fname[:strings.LastIndex(fname, ".")]))
+		ldparams = append(ldparams, object(f))
+		inputs = append(inputs, object(f))
 	}
 
 	haslib := false
@@ -100,16 +95,24 @@ func (cb *CBin) Build(c *build.Context) error {
 		}
 	}
 
-	if err := c.Exec(Linker(), CCENV, ldparams); err != nil {
-		return fmt.Errorf(err.Error())
-	}
+	actions = append(actions, &build.Action{
+		Description: fmt.Sprintf("link %s", cb.Name),
+		Cmd:         Linker(),
+		Params:      ldparams,
+		Env:         ccEnv(),
+		Inputs:      inputs,
+		Outputs:     []string{cb.Name},
+	})
 	if cb.Strip {
-		sparams := []string{"-o", cb.Name, cb.Name}
-		if err := c.Exec(Stripper(), nil, sparams); err != nil {
-			return fmt.Errorf(err.Error())
-		}
+		actions = append(actions, &build.Action{
+			Description: fmt.Sprintf("strip %s", cb.Name),
+			Cmd:         Stripper(),
+			Params:      []string{"-o", cb.Name, cb.Name},
+			Inputs:      []string{cb.Name},
+			Outputs:     []string{cb.Name},
+		})
 	}
-	return nil
+	return actions
 }
 
 func (cb *CBin) Installs() map[string]string {
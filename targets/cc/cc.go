@@ -8,43 +8,18 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"path/filepath"
 
 	"strings"
 
 	"os"
 
+	"bldy.build/build"
 	"bldy.build/build/internal"
 	"bldy.build/build/util"
 )
 
-var (
-	CCVersion = ""
-	cc        = ""
-	ld        = ""
-	ar        = ""
-	CCENV     = os.Environ()
-)
-
 func init() {
-
-	CCENV = append(CCENV, fmt.Sprintf("%s=%s", "C_INCLUDE_PATH", "include"))
-	CCENV = append(CCENV, fmt.Sprintf("%s=%s", "LIBRARY_PATH", "lib"))
-
-	if cc = util.Getenv("CC"); cc == "" {
-		cc = "CC"
-	}
-	if ld = util.Getenv("LD"); ld == "" {
-		ld = "ld"
-	}
-	if ar = util.Getenv("AR"); ar == "" {
-		ar = "ar"
-	}
-
-	if out, err := exec.Command(Compiler(), "--version").Output(); err != nil {
-		CCVersion = "deadbeef"
-	} else {
-		CCVersion = strings.TrimSpace(string(out))
-	}
 	if err := internal.Register("cc_library", CLib{}); err != nil {
 		log.Fatal(err)
 	}
@@ -56,29 +31,63 @@ func init() {
 	}
 }
 
+// The tools and the environment commands run with are read from the
+// environment every time they are used instead of once, the build server
+// builds for clients with different environments.
+
+// tool returns the tool named in the environment variable, or def.
+func tool(name, def string) string {
+	if t := util.Getenv(name); t != "" {
+		return t
+	}
+	return def
+}
+
 func Compiler() string {
 	if tpfx := util.Getenv("TOOLPREFIX"); tpfx == "" {
-		return cc
+		return tool("CC", "CC")
 	} else {
-		return fmt.Sprintf("%s%s", tpfx, cc)
+		return fmt.Sprintf("%s%s", tpfx, tool("CC", "CC"))
 	}
 }
 
 func Archiver() string {
 	if tpfx := util.Getenv("TOOLPREFIX"); tpfx == "" {
-		return ar
+		return tool("AR", "ar")
 	} else {
-		return fmt.Sprintf("%s%s", tpfx, ar)
+		return fmt.Sprintf("%s%s", tpfx, tool("AR", "ar"))
 	}
 }
 func Linker() string {
 	if tpfx := util.Getenv("TOOLPREFIX"); tpfx == "" {
-		return ld
+		return tool("LD", "ld")
 	} else {
-		return fmt.Sprintf("%s%s", tpfx, ld)
+		return fmt.Sprintf("%s%s", tpfx, tool("LD", "ld"))
 	}
 }
 
+// CCVersion returns the version of the compiler.
+func CCVersion() string {
+	return util.Version(Compiler())
+}
+
+// Tools returns the tools targets are built with, along with the binaries
+// they are found at, so targets built with other tools are hashed apart.
+func Tools() (tools []string) {
+	for _, t := range []string{Compiler(), Linker(), Archiver()} {
+		if bin, err := exec.LookPath(t); err == nil {
+			t = fmt.Sprintf("%s=%s", t, bin)
+		}
+		tools = append(tools, t)
+	}
+	return tools
+}
+
+// ccEnv returns the environment commands are run with.
+func ccEnv() []string {
+	return append(os.Environ(), "C_INCLUDE_PATH=include", "LIBRARY_PATH=lib")
+}
+
 // Had to be done
 func Stripper() string {
 	if tpfx := util.Getenv("TOOLPREFIX"); tpfx == "" {
@@ -98,3 +107,38 @@ func (s Includes) Includes() (incs []string) {
 	}
 	return
 }
+
+// object returns the name of the object file the source is compiled to.
+func object(src string) string {
+	_, fname := filepath.Split(src)
+	if i := strings.LastIndex(fname, "."); i > 0 {
+		fname = fname[:i]
+	}
+	return fmt.Sprintf("%s.o", fname)
+}
+
+// compile returns the actions that compile each of the sources on their
+// own, so they are compiled in parallel and cached separately.
+func compile(srcs []string, includes Includes, headers []string, flags []string) (actions []*build.Action) {
+	for _, src := range srcs {
+		params := []string{"-c"}
+		params = append(params, flags...)
+		params = append(params, includes.Includes()...)
+		params = append(params, src, "-o", object(src))
+
+		// headers may be included from next to the source, the include
+		// paths or the exported headers of dependencies.
+		inputs := []string{src, filepath.Join(filepath.Dir(src), "*.h"), "include"}
+		inputs = append(inputs, includes...)
+		inputs = append(inputs, headers...)
+		actions = append(actions, &build.Action{
+			Description: fmt.Sprintf("compile %s", src),
+			Cmd:         Compiler(),
+			Params:      params,
+			Env:         ccEnv(),
+			Inputs:      inputs,
+			Outputs:     []string{object(src)},
+		})
+	}
+	return actions
+}
@@ -5,13 +5,8 @@
 package cc
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"io"
-	"strings"
-
-	"bldy.build/build/util"
-
 	"path/filepath"
 
 	"bldy.build/build"
@@ -30,48 +25,44 @@ type CLib struct {
 }
 
 func (cl *CLib) Hash() []byte {
-	h := sha1.New()
+	return build.Hash(cl)
+}
 
-	io.WriteString(h, CCVersion)
-	io.WriteString(h, cl.Name)
-	io.WriteString(h, "clib")
-	util.HashFilesWithExt(h, []string(cl.Includes), ".h")
-	util.HashFilesWithExt(h, cl.Sources, ".c")
-	util.HashStrings(h, cl.CompilerOptions)
-	util.HashStrings(h, cl.LinkerOptions)
-	if cl.LinkStatic {
-		io.WriteString(h, "static")
-	}
-	return h.Sum(nil)
+// HashInputs hashes the version of the compiler along with the attributes.
+func (cl *CLib) HashInputs(h io.Writer) {
+	fmt.Fprintf(h, "CCVersion %q\n", CCVersion())
+	fmt.Fprintf(h, "Tools %q\n", Tools())
+	build.HashFields(h, cl)
 }
 
 func (cl *CLib) Build(c *build.Context) error {
-	params := []string{"-c"}
-	params = append(params, cl.CompilerOptions...)
-	params = append(params, cl.LinkerOptions...)
-	params = append(params, cl.Includes.Includes()...)
-	params = append(params, cl.Sources...)
+	return build.RunActions(c, cl.Actions())
+}
 
-	if err := c.Exec(Compiler(), CCENV, params); err != nil {
-		return fmt.Errorf(err.Error())
-	}
+func (cl *CLib) Actions() []*build.Action {
+	flags := append([]string{}, cl.CompilerOptions...)
+	flags = append(flags, cl.LinkerOptions...)
+	actions := compile(cl.Sources, cl.Includes, cl.Headers, flags)
 
 	libName := fmt.Sprintf("%s.a", cl.Name)
-	params = []string{"-rs", libName}
+	params := []string{"-rs", libName}
 	params = append(params, cl.LinkerOptions...)
-	// This is done under the assumption that each src file put in this thing
-	// here will comeout as a .o file
+	var objects []string
 	for _, f := range cl.Sources {
-		_, filename := filepath.Split(f)
-		params = append(params, fmt.Sprintf("%s.o", filename[:strings.LastIndex(filename, ".")]))
+		objects = append(objects, object(f))
 	}
+	params = append(params, objects...)
 
-	if err := c.Exec(Archiver(), CCENV, params); err != nil {
-		return fmt.Errorf(err.Error())
-	}
-
-	return nil
+	return append(actions, &build.Action{
+		Description: fmt.Sprintf("archive %s", libName),
+		Cmd:         Archiver(),
+		Params:      params,
+		Env:         ccEnv(),
+		Inputs:      objects,
+		Outputs:     []string{libName},
+	})
 }
+
 func (cl *CLib) Installs() map[string]string {
 	exports := make(map[string]string)
 	libName := fmt.Sprintf("%s.a", cl.Name)
@@ -5,14 +5,12 @@
 package golang
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 )
 
 type GoBuild struct {
@@ -30,11 +28,13 @@ func (g *GoBuild) GetDependencies() []string {
 }
 
 func (g *GoBuild) Hash() []byte {
-	h := sha1.New()
-	io.WriteString(h, gover)
-	io.WriteString(h, g.Name)
-	util.HashFiles(h, []string(g.Sources))
-	return h.Sum(nil)
+	return build.Hash(g)
+}
+
+// HashInputs hashes the version of go along with the attributes.
+func (g *GoBuild) HashInputs(h io.Writer) {
+	fmt.Fprintf(h, "GoVersion %q\n", version())
+	build.HashFields(h, g)
 }
 
 func (g *GoBuild) Build(c *build.Context) error {
@@ -6,26 +6,21 @@ package golang
 
 import (
 	"log"
-	"os/exec"
-	"strings"
 
 	"bldy.build/build/internal"
-)
-
-var (
-	gover string
+	"bldy.build/build/util"
 )
 
 func init() {
 	if err := internal.Register("go_build", GoBuild{}); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	if out, err := exec.Command(Compiler(), "--version").Output(); err != nil {
-		gover = "deadbeef"
-	} else {
-		gover = strings.TrimSpace(string(out))
-	}
+// version returns the version of go, it is read for every build since
+// the build server builds for clients with different environments.
+func version() string {
+	return util.Version(Compiler())
 }
 
 func Compiler() string {
@@ -1,18 +1,14 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
 
 	"os"
 	"text/template"
 
-	"io"
 	"strings"
 
-	"bldy.build/build/util"
-
 	"path/filepath"
 
 	"bldy.build/build"
@@ -40,18 +36,7 @@ func split(s string, c string) string {
 	return s[i+1:]
 }
 func (k *Config) Hash() []byte {
-
-	h := sha1.New()
-	util.HashFiles(h, k.RamFiles)
-	util.HashStrings(h, k.Code)
-	util.HashStrings(h, k.Dev)
-	util.HashStrings(h, k.Ip)
-	util.HashStrings(h, k.Link)
-	util.HashStrings(h, k.Sd)
-	util.HashStrings(h, k.Uart)
-	util.HashStrings(h, k.VGA)
-	io.WriteString(h, k.Name)
-	return h.Sum(nil)
+	return build.Hash(k)
 }
 
 func (k *Config) Build(c *build.Context) error {
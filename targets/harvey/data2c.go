@@ -1,9 +1,7 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 
 	"io/ioutil"
 
@@ -26,12 +24,7 @@ func (dtc *DataToC) GetDependencies() []string {
 }
 
 func (dtc *DataToC) Hash() []byte {
-	h := sha1.New()
-
-	io.WriteString(h, dtc.Name)
-	io.WriteString(h, dtc.Bin)
-	io.WriteString(h, dtc.Prefix)
-	return []byte{}
+	return build.Hash(dtc)
 }
 
 func (dtc *DataToC) Build(c *build.Context) error {
@@ -1,7 +1,6 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"debug/elf"
 	"fmt"
 	"io"
@@ -34,11 +33,7 @@ func (etc *ElfToC) Installs() map[string]string {
 }
 
 func (etc *ElfToC) Hash() []byte {
-	h := sha1.New()
-
-	io.WriteString(h, etc.Name)
-	io.WriteString(h, etc.Elf)
-	return []byte{}
+	return build.Hash(etc)
 }
 
 func (etc *ElfToC) Build(c *build.Context) error {
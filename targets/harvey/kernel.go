@@ -1,12 +1,6 @@
 package harvey
 
-import (
-	"crypto/sha1"
-
-	"io"
-
-	"bldy.build/build"
-)
+import "bldy.build/build"
 
 type Move struct {
 	Name         string            `move:"name"`
@@ -15,11 +9,7 @@ type Move struct {
 }
 
 func (m *Move) Hash() []byte {
-
-	h := sha1.New()
-
-	io.WriteString(h, m.Name)
-	return h.Sum(nil)
+	return build.Hash(m)
 }
 
 func (m *Move) Build(c *build.Context) error {
@@ -1,17 +1,13 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"path/filepath"
 
 	"strings"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 )
-import "os"
 
 type ManPage struct {
 	Name         string   `man_page:"name"`
@@ -20,13 +16,7 @@ type ManPage struct {
 }
 
 func (mp *ManPage) Hash() []byte {
-	h := sha1.New()
-
-	io.WriteString(h, mp.Name)
-
-	util.HashFiles(h, mp.Sources)
-	util.HashStrings(h, os.Environ())
-	return []byte{}
+	return build.Hash(mp)
 }
 
 func (mp *ManPage) Build(c *build.Context) error {
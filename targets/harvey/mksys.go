@@ -11,10 +11,8 @@ package harvey
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"path/filepath"
@@ -22,7 +20,6 @@ import (
 	"text/template"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 	"sevki.org/lib/prettyprint"
 )
 
@@ -69,14 +66,7 @@ type MkSys struct {
 }
 
 func (cl *MkSys) Hash() []byte {
-
-	h := sha1.New()
-	io.WriteString(h, cl.Mode)
-	io.WriteString(h, cl.ARCH)
-	io.WriteString(h, cl.Mode)
-	util.HashFiles(h, []string{cl.SysConf})
-
-	return h.Sum(nil)
+	return build.Hash(cl)
 }
 
 func (mkSys *MkSys) readSysconf() (*Sysconf, error) {
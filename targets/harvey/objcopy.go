@@ -5,9 +5,7 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"path/filepath"
 
 	"bldy.build/build"
@@ -30,11 +28,7 @@ func (oc *ObjCopy) GetDependencies() []string {
 }
 
 func (oc *ObjCopy) Hash() []byte {
-	h := sha1.New()
-	io.WriteString(h, oc.In)
-	io.WriteString(h, oc.Out)
-	io.WriteString(h, oc.Name)
-	return []byte{}
+	return build.Hash(oc)
 }
 
 // Had to be done
@@ -5,14 +5,10 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"os"
 	"path"
 
-	"github.com/nu7hatch/gouuid"
-
 	"bldy.build/build"
 )
 
@@ -31,10 +27,7 @@ func (ob *OldBuild) GetDependencies() []string {
 }
 
 func (s *OldBuild) Hash() []byte {
-	h := sha1.New()
-	u, _ := uuid.NewV4()
-	io.WriteString(h, u.String())
-	return []byte{}
+	return build.Hash(s)
 }
 
 func oldbuild() string {
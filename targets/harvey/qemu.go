@@ -43,7 +43,16 @@ func (q *Qemu) GetDependencies() []string {
 }
 
 func (q *Qemu) Hash() []byte {
-	return []byte{}
+	return build.Hash(q)
+}
+
+// Claim makes qemu targets exclusive, the tests they run time out when
+// the machine is busy building other targets.
+func (q *Qemu) Claim() build.Claim {
+	return build.Claim{
+		Resources: build.Resources{"cpu": 1},
+		Exclusive: true,
+	}
 }
 
 func (q *Qemu) Build(c *build.Context) error {
@@ -100,7 +109,7 @@ func (q *Qemu) Build(c *build.Context) error {
 
 	fmt.Println(append([]string{system}, params...))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	ctx, cancel := context.WithTimeout(c.Context(), 1*time.Minute)
 
 	x := c.Run(ctx, system, nil, params)
 	var wg sync.WaitGroup
@@ -5,8 +5,6 @@
 package harvey
 
 import (
-	"crypto/sha1"
-	"io"
 	"os/exec"
 
 	"bldy.build/build"
@@ -29,10 +27,7 @@ func (s *Sed) GetDependencies() []string {
 }
 
 func (s *Sed) Hash() []byte {
-	h := sha1.New()
-
-	io.WriteString(h, s.Name)
-	return []byte{}
+	return build.Hash(s)
 }
 
 func (s *Sed) Build(c *build.Context) error {
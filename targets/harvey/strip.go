@@ -5,9 +5,7 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"path/filepath"
 
 	"bldy.build/build"
@@ -28,9 +26,7 @@ func (s *Strip) GetDependencies() []string {
 }
 
 func (s *Strip) Hash() []byte {
-	h := sha1.New()
-	io.WriteString(h, s.Name)
-	return []byte{}
+	return build.Hash(s)
 }
 
 // Had to be done
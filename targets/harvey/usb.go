@@ -5,16 +5,13 @@
 package harvey
 
 import (
-	"crypto/sha1"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"text/template"
 
 	"bldy.build/build"
-	"bldy.build/build/util"
 )
 
 type Embed struct {
@@ -44,10 +41,7 @@ func (u *USB) GetDependencies() []string {
 }
 
 func (u *USB) Hash() []byte {
-	h := sha1.New()
-	util.HashFiles(h, []string{u.Conf})
-	io.WriteString(h, u.Name)
-	return []byte{}
+	return build.Hash(u)
 }
 func (s *USB) Installs() map[string]string {
 	installs := make(map[string]string)
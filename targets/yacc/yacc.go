@@ -6,10 +6,8 @@ package cc
 
 import (
 	"bytes"
-	"crypto/sha1"
 
 	"io"
-	"os/exec"
 	"path/filepath"
 
 	"log"
@@ -23,8 +21,6 @@ import (
 	"bldy.build/build/util"
 )
 
-var YaccVersion = ""
-
 type Yacc struct {
 	Name           string   `yacc:"name"`
 	Sources        []string `yacc:"srcs" build:"path"`
@@ -37,23 +33,25 @@ type Yacc struct {
 }
 
 func init() {
-	if out, err := exec.Command("yacc", "--version").Output(); err != nil {
-		YaccVersion = "deadbeef"
-	} else {
-		YaccVersion = strings.TrimSpace(string(out))
-	}
-
 	if err := internal.Register("yacc", Yacc{}); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// YaccVersion returns the version of yacc, it is read for every build
+// since the build server builds for clients with different environments.
+func YaccVersion() string {
+	return util.Version("yacc")
+}
+
 func (y *Yacc) Hash() []byte {
-	h := sha1.New()
-	io.WriteString(h, YaccVersion)
-	io.WriteString(h, y.Name)
-	util.HashFiles(h, y.Sources)
-	util.HashStrings(h, y.YaccOptions)
-	return h.Sum(nil)
+	return build.Hash(y)
+}
+
+// HashInputs hashes the version of yacc along with the attributes.
+func (y *Yacc) HashInputs(h io.Writer) {
+	fmt.Fprintf(h, "YaccVersion %q\n", YaccVersion())
+	build.HashFields(h, y)
 }
 
 func (y *Yacc) Build(c *build.Context) error {
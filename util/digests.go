@@ -0,0 +1,109 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// racyWindow is how long after it was modified a file may still be
+// modified again without its timestamps changing, on file systems that
+// only keep seconds or less. Digests of files that were modified this
+// recently aren't kept.
+var racyWindow = 2 * time.Second
+
+// FileDigest is the digest of a file, along with the stat information
+// it is valid for.
+type FileDigest struct {
+	Inode  uint64 `json:"ino"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	Ctime  int64  `json:"ctime"`
+	Digest []byte `json:"digest"`
+}
+
+// FileDigests are digests of files keyed by path.
+type FileDigests map[string]FileDigest
+
+var digests = struct {
+	sync.Mutex
+	m     FileDigests
+	dirty bool
+}{m: make(FileDigests)}
+
+// LoadDigests adds digests from a previous run, files that haven't
+// changed since aren't read again.
+func LoadDigests(d FileDigests) {
+	digests.Lock()
+	defer digests.Unlock()
+	for path, fd := range d {
+		if _, ok := digests.m[path]; !ok {
+			digests.m[path] = fd
+		}
+	}
+}
+
+// Digests returns the digests of the files that were hashed and whether
+// any of them changed since they were loaded.
+func Digests() (FileDigests, bool) {
+	digests.Lock()
+	defer digests.Unlock()
+	d := make(FileDigests, len(digests.m))
+	for path, fd := range digests.m {
+		d[path] = fd
+	}
+	return d, digests.dirty
+}
+
+// DigestFile returns the sha256 digest of the file at path, which has the
+// stat information fi. The file is only read if it changed since it was
+// last digested.
+func DigestFile(path string, fi os.FileInfo) ([]byte, error) {
+	inode, ctime := statInfo(fi)
+	fd := FileDigest{
+		Inode: inode,
+		Size:  fi.Size(),
+		Mtime: fi.ModTime().UnixNano(),
+		Ctime: ctime,
+	}
+
+	digests.Lock()
+	old, ok := digests.m[path]
+	digests.Unlock()
+	if ok && old.Inode == fd.Inode && old.Size == fd.Size && old.Mtime == fd.Mtime && old.Ctime == fd.Ctime {
+		return old.Digest, nil
+	}
+
+	// the time is taken before the file is read, if the file is modified
+	// while it's being read its timestamps will be racy.
+	now := time.Now().UnixNano()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	fd.Digest = h.Sum(nil)
+
+	digests.Lock()
+	defer digests.Unlock()
+	if now-fd.Mtime < int64(racyWindow) || now-fd.Ctime < int64(racyWindow) {
+		if ok {
+			delete(digests.m, path)
+			digests.dirty = true
+		}
+		return fd.Digest, nil
+	}
+	digests.m[path] = fd
+	digests.dirty = true
+	return fd.Digest, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func digest(t *testing.T, path string) []byte {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DigestFile(path, fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDigestFile(t *testing.T) {
+	defer func(w time.Duration) { racyWindow = w }(racyWindow)
+	dir, err := ioutil.TempDir("", "digests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.c")
+	if err := ioutil.WriteFile(path, []byte("int a;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// files modified within the racy window aren't remembered.
+	racyWindow = time.Hour
+	digest(t, path)
+	if d, _ := Digests(); len(d[path].Digest) > 0 {
+		t.Errorf("digest of a file that was just modified was kept")
+	}
+
+	racyWindow = 0
+	a := digest(t, path)
+	if d, _ := Digests(); !bytes.Equal(d[path].Digest, a) {
+		t.Errorf("digest of %s wasn't kept", path)
+	}
+
+	// the contents change without the size or modification time
+	// changing.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("int b;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, digest(t, path)) {
+		t.Errorf("digest didn't change when the contents of %s changed", path)
+	}
+}
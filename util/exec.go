@@ -5,8 +5,11 @@
 package util
 
 import (
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 	"sync"
 )
 
@@ -46,3 +49,38 @@ func Exec(o, e io.Writer, cmd string, env, params []string) error {
 	wg.Wait()
 	return nil
 }
+
+var (
+	versionsMu sync.Mutex
+	versions   = make(map[string]string)
+)
+
+// Version returns what the command prints for --version, or deadbeef if it
+// can't be run. Versions are kept by the binary the command is found at in
+// the PATH at the time, so a different or upgraded binary is run again.
+func Version(cmd string) string {
+	bin, err := exec.LookPath(cmd)
+	if err != nil {
+		return "deadbeef"
+	}
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return "deadbeef"
+	}
+	key := fmt.Sprintf("%s %d %d", bin, fi.Size(), fi.ModTime().UnixNano())
+	versionsMu.Lock()
+	v, ok := versions[key]
+	versionsMu.Unlock()
+	if ok {
+		return v
+	}
+	if out, err := exec.Command(bin, "--version").Output(); err != nil {
+		v = "deadbeef"
+	} else {
+		v = strings.TrimSpace(string(out))
+	}
+	versionsMu.Lock()
+	versions[key] = v
+	versionsMu.Unlock()
+	return v
+}
@@ -7,7 +7,9 @@ package util
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -75,3 +77,32 @@ func TestCC(t *testing.T) {
 	os.Remove(helloWorldFile)
 	os.Remove("helloworld")
 }
+
+func TestVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	tool := filepath.Join(dir, "tool")
+	write := func(version string) {
+		if err := ioutil.WriteFile(tool, []byte("#!/bin/sh\necho "+version+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1.0")
+	if v := Version("tool"); v != "1.0" {
+		t.Errorf("got version %q, expected 1.0", v)
+	}
+	// an upgraded tool is run again.
+	write("1.10")
+	if v := Version("tool"); v != "1.10" {
+		t.Errorf("got version %q after the upgrade, expected 1.10", v)
+	}
+	if v := Version("missing"); v != "deadbeef" {
+		t.Errorf("got version %q of a missing tool", v)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2016 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// statInfo returns the inode number and the time the inode of a file
+// changed, which changes even if the modification time is set back.
+func statInfo(fi os.FileInfo) (inode uint64, ctime int64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return st.Ino, st.Ctim.Nano()
+}
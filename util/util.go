@@ -72,30 +72,50 @@ RESTART:
 			continue
 		}
 
-		f, err := os.Open(file)
+		stat, err := os.Stat(file)
 
 		if err != nil {
 			log.Fatalf("hash files: %s\n", err.Error())
 		}
 
-		stat, _ := f.Stat()
 		if stat.IsDir() {
 			fsm = append([]string{}, fsm[i+1:]...)
-			fs, _ := f.Readdir(-1)
-			for _, x := range fs {
+			for _, x := range readDir(file) {
 				fsm = append(fsm, (filepath.Join(file, x.Name())))
 			}
 			goto RESTART /* to avoid out of bound errors, there may be no files
 			in the folder */
 		}
 
-		fmt.Fprintf(h, "file %s\n", filepath.Join(pp, file))
-		n, _ := io.Copy(h, f)
-		fmt.Fprintf(h, "%d bytes\n", n)
-		f.Close()
+		hashFile(h, file, stat)
 	}
 }
 
+// hashFile writes the digest of a file to h, files that didn't change
+// since they were last hashed aren't read again.
+func hashFile(h io.Writer, file string, stat os.FileInfo) {
+	digest, err := DigestFile(file, stat)
+	if err != nil {
+		log.Fatalf("hash files: %s\n", err.Error())
+	}
+	// files in the project are hashed by their relative path, so hashes
+	// are the same in every checkout.
+	if rel, err := filepath.Rel(pp, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+	fmt.Fprintf(h, "file %s %x %d bytes\n", file, digest, stat.Size())
+}
+
+func readDir(dir string) []os.FileInfo {
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Fatalf("hash files: %s\n", err.Error())
+	}
+	defer f.Close()
+	fs, _ := f.Readdir(-1)
+	return fs
+}
+
 func BuildOut() string {
 	if Getenv("BUILD_OUT") != "" {
 		return Getenv("BUILD_OUT")
@@ -121,17 +141,15 @@ RESTART:
 		if filepath.Base(file) == BuildOut() {
 			continue
 		}
-		f, err := os.Open(file)
+		stat, err := os.Stat(file)
 
 		if err != nil {
 			log.Fatalf("hash files: %s\n", err.Error())
 		}
 
-		stat, _ := f.Stat()
 		if stat.IsDir() {
 			fsm = append([]string{}, fsm[i+1:]...)
-			fs, _ := f.Readdir(-1)
-			for _, x := range fs {
+			for _, x := range readDir(file) {
 				if filepath.Ext(x.Name()) == ext || filepath.Ext(x.Name()) == "" {
 					fsm = append(fsm, (filepath.Join(file, x.Name())))
 				}
@@ -141,14 +159,10 @@ RESTART:
 			in the folder */
 		}
 		if filepath.Ext(file) != ext {
-			f.Close()
 			continue
 		}
 
-		fmt.Fprintf(h, "file %s\n", filepath.Join(pp, file))
-		n, _ := io.Copy(h, f)
-		fmt.Fprintf(h, "%d bytes\n", n)
-		f.Close()
+		hashFile(h, file, stat)
 	}
 }
 